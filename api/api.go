@@ -3,32 +3,17 @@ package api
 import (
 	"bytes"
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
-	"io/ioutil"
 	"net/http"
 	"time"
 
 	"github.com/rs/zerolog"
-	"github.com/swoga/ufiber-exporter/config"
 	"github.com/swoga/ufiber-exporter/model"
 )
 
-var (
-	httpClient = &http.Client{
-		Transport: &http.Transport{
-			MaxIdleConns:        100,
-			MaxIdleConnsPerHost: 5,
-			TLSClientConfig:     &tls.Config{InsecureSkipVerify: true},
-		},
-		Timeout: time.Duration(5 * time.Minute),
-	}
-)
-
-func request(ctx context.Context, log zerolog.Logger, device config.Device, auth string, method string, url string, data interface{}) (res *http.Response, err error) {
+func (c *Client) request(ctx context.Context, log zerolog.Logger, auth string, method string, endpoint string, data interface{}) (res *http.Response, err error) {
 	var buf io.Reader
 	if data != nil {
 		body, err := json.Marshal(data)
@@ -38,7 +23,7 @@ func request(ctx context.Context, log zerolog.Logger, device config.Device, auth
 		buf = bytes.NewBuffer(body)
 	}
 
-	url = fmt.Sprintf("https://%s/api/v1.0/%s", device.Address, url)
+	url := c.baseURL + endpoint
 	log.Debug().Str("method", method).Str("url", url).Msg("send request")
 
 	req, err := http.NewRequestWithContext(ctx, method, url, buf)
@@ -50,154 +35,199 @@ func request(ctx context.Context, log zerolog.Logger, device config.Device, auth
 		req.Header.Add("X-Auth-Token", auth)
 	}
 
-	res, err = httpClient.Do(req)
+	res, err = c.httpClient.Do(req)
 	if err != nil {
 		return
 	}
 
 	if res.StatusCode != 200 {
-		err = errors.New("non-200 response")
-	}
-
-	if err != nil {
-		data, _ := ioutil.ReadAll(res.Body)
-		log.Error().Str("response", string(data)).Msg("error from API")
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		log.Error().Str("response", string(body)).Msg("error from API")
+		err = newStatusError(endpoint, res.StatusCode, body)
+		var authErr *AuthError
+		if errors.As(err, &authErr) {
+			authErr.Token = auth
+		}
+		return nil, err
 	}
 
 	return
 }
 
-func DoLogin(ctx context.Context, log zerolog.Logger, device config.Device) (res *http.Response, err error) {
-	login := &model.LoginRequest{
-		Username: *device.Username,
-		Password: *device.Password,
+// newStatusError classifies a non-200 response into an AuthError, ServerError or StatusError
+func newStatusError(endpoint string, statusCode int, body []byte) error {
+	statusErr := &StatusError{
+		Endpoint:   endpoint,
+		StatusCode: statusCode,
+		Body:       string(body),
+	}
+	var response model.ErrorResponse
+	if json.Unmarshal(body, &response) == nil {
+		statusErr.Response = &response
+	}
+
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		return &AuthError{
+			Endpoint: endpoint,
+			Response: statusErr.Response,
+			Err:      statusErr,
+		}
+	case statusCode >= 500:
+		return &ServerError{Err: statusErr}
 	}
+	return statusErr
+}
 
-	res, err = request(ctx, log, device, "", "POST", "user/login", login)
+// get requests endpoint with the current session token and decodes the JSON response into data.
+// Concurrent requests of the same endpoint and token share one response,
+// a request with a new token does not join one with a rejected token.
+func (c *Client) get(ctx context.Context, log zerolog.Logger, endpoint string, data interface{}) error {
+	auth, err := c.Token(ctx, log)
 	if err != nil {
-		return
+		return err
 	}
 
-	var data model.LoginResponse
+	body, err := c.gets.do(ctx, auth+" "+endpoint, func(ctx context.Context) ([]byte, error) {
+		return c.getBody(ctx, log, auth, endpoint)
+	}, func() {
+		log.Debug().Str("endpoint", endpoint).Msg("shared response of in-flight request")
+		deduplicatedRequests.WithLabelValues(endpoint).Inc()
+	})
+	if err != nil {
+		return err
+	}
 
-	decoder := json.NewDecoder(res.Body)
-	err = decoder.Decode(&data)
+	err = json.Unmarshal(body, data)
 	if err != nil {
-		return
+		requestErrors.WithLabelValues(endpoint, "decode").Inc()
+		return &DecodeError{Endpoint: endpoint, Err: err}
 	}
 
 	log.Debug().Interface("data", data).Msg("response")
 
-	defer res.Body.Close()
+	return nil
+}
 
-	if data.Error != 0 {
-		err = errors.New("error != 0")
+func (c *Client) getBody(ctx context.Context, log zerolog.Logger, auth string, endpoint string) (body []byte, err error) {
+	start := time.Now()
+	defer func() { observeRequest(endpoint, start, err) }()
+
+	res, err := c.request(ctx, log, auth, "GET", endpoint, nil)
+	if err != nil {
+		return nil, err
 	}
+	defer res.Body.Close()
 
-	return
+	return io.ReadAll(res.Body)
 }
 
-func GetStatistics(ctx context.Context, log zerolog.Logger, device config.Device, auth string) (*model.Statistics, error) {
-	res, err := request(ctx, log, device, auth, "GET", "statistics", nil)
+// Login creates a new session on the device and stores its token in the client
+func (c *Client) Login(ctx context.Context, log zerolog.Logger) (err error) {
+	start := time.Now()
+	defer func() { observeRequest("user/login", start, err) }()
+
+	login := &model.LoginRequest{
+		Username: *c.device.Username,
+		Password: string(*c.device.Password),
+	}
+
+	res, err := c.request(ctx, log, "", "POST", "user/login", login)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	defer res.Body.Close()
 
-	var data []model.Statistics
+	var data model.LoginResponse
 
 	decoder := json.NewDecoder(res.Body)
 	err = decoder.Decode(&data)
 	if err != nil {
-		return nil, err
+		return &DecodeError{Endpoint: "user/login", Err: err}
 	}
 
 	log.Debug().Interface("data", data).Msg("response")
 
-	defer res.Body.Close()
+	if data.Error != 0 {
+		return &AuthError{
+			Endpoint: "user/login",
+			Response: &model.ErrorResponse{
+				Detail:     data.Detail,
+				Error:      data.Error,
+				Message:    data.Message,
+				StatusCode: data.StatusCode,
+			},
+		}
+	}
 
-	return &data[0], nil
+	auth := res.Header.Get("X-Auth-Token")
+	if auth == "" {
+		return &AuthError{Endpoint: "user/login", Err: errors.New("no X-Auth-Token after login")}
+	}
+	c.SetSession(Session{
+		Token:  auth,
+		Issued: time.Now(),
+	})
+
+	return nil
 }
 
-func GetInterfaces(ctx context.Context, log zerolog.Logger, device config.Device, auth string) (*[]model.InterfacesInterface, error) {
-	res, err := request(ctx, log, device, auth, "GET", "interfaces", nil)
+func (c *Client) GetStatistics(ctx context.Context, log zerolog.Logger) (*model.Statistics, error) {
+	var data []model.Statistics
+
+	err := c.get(ctx, log, "statistics", &data)
 	if err != nil {
 		return nil, err
 	}
 
+	if len(data) == 0 {
+		return nil, &DecodeError{Endpoint: "statistics", Err: errors.New("empty response")}
+	}
+
+	return &data[0], nil
+}
+
+func (c *Client) GetInterfaces(ctx context.Context, log zerolog.Logger) (*[]model.InterfacesInterface, error) {
 	var data []model.InterfacesInterface
 
-	decoder := json.NewDecoder(res.Body)
-	err = decoder.Decode(&data)
+	err := c.get(ctx, log, "interfaces", &data)
 	if err != nil {
 		return nil, err
 	}
 
-	log.Debug().Interface("data", data).Msg("response")
-
-	defer res.Body.Close()
-
 	return &data, nil
 }
 
-func GetONUs(ctx context.Context, log zerolog.Logger, device config.Device, auth string) (*[]model.ONU, error) {
-	res, err := request(ctx, log, device, auth, "GET", "gpon/onus", nil)
-	if err != nil {
-		return nil, err
-	}
-
+func (c *Client) GetONUs(ctx context.Context, log zerolog.Logger) (*[]model.ONU, error) {
 	var data []model.ONU
 
-	decoder := json.NewDecoder(res.Body)
-	err = decoder.Decode(&data)
+	err := c.get(ctx, log, "gpon/onus", &data)
 	if err != nil {
 		return nil, err
 	}
 
-	log.Debug().Interface("data", data).Msg("response")
-
-	defer res.Body.Close()
-
 	return &data, nil
 }
 
-func GetONUsSettings(ctx context.Context, log zerolog.Logger, device config.Device, auth string) (*[]model.ONUSettings, error) {
-	res, err := request(ctx, log, device, auth, "GET", "gpon/onus/settings", nil)
-	if err != nil {
-		return nil, err
-	}
-
+func (c *Client) GetONUsSettings(ctx context.Context, log zerolog.Logger) (*[]model.ONUSettings, error) {
 	var data []model.ONUSettings
 
-	decoder := json.NewDecoder(res.Body)
-	err = decoder.Decode(&data)
+	err := c.get(ctx, log, "gpon/onus/settings", &data)
 	if err != nil {
 		return nil, err
 	}
 
-	log.Debug().Interface("data", data).Msg("response")
-
-	defer res.Body.Close()
-
 	return &data, nil
 }
 
-func GetMACTable(ctx context.Context, log zerolog.Logger, device config.Device, auth string) (*[]model.MACTable, error) {
-	res, err := request(ctx, log, device, auth, "GET", "tools/mac-table", nil)
-	if err != nil {
-		return nil, err
-	}
-
+func (c *Client) GetMACTable(ctx context.Context, log zerolog.Logger) (*[]model.MACTable, error) {
 	var data []model.MACTable
 
-	decoder := json.NewDecoder(res.Body)
-	err = decoder.Decode(&data)
+	err := c.get(ctx, log, "tools/mac-table", &data)
 	if err != nil {
 		return nil, err
 	}
 
-	log.Debug().Interface("data", data).Msg("response")
-
-	defer res.Body.Close()
-
 	return &data, nil
 }
@@ -0,0 +1,296 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/swoga/ufiber-exporter/config"
+	"github.com/swoga/ufiber-exporter/model"
+)
+
+// Client talks to the API of a single UFiber OLT.
+// It owns the HTTP transport and the session of the device.
+type Client struct {
+	device      config.Device
+	baseURL     string
+	httpClient  *http.Client
+	concurrency int
+	maxAge      time.Duration
+
+	logins group[struct{}]
+	gets   group[[]byte]
+
+	mutex   sync.RWMutex
+	session Session
+	// expired is set if the session was reset after its token has been rejected
+	expired bool
+}
+
+func NewClient(device config.Device) (*Client, error) {
+	concurrency := 1
+	if device.Concurrency != nil && *device.Concurrency > 0 {
+		concurrency = *device.Concurrency
+	}
+	var maxAge time.Duration
+	if device.TokenMaxAge != nil {
+		maxAge = time.Duration(*device.TokenMaxAge * float64(time.Second))
+	}
+
+	tlsConfig := config.DefaultTLSConfig()
+	if device.TLSConfig != nil {
+		tlsConfig = *device.TLSConfig
+	}
+	tlsClientConfig, err := newTLSConfig(device.Address, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	httpConfig := config.DefaultHTTPConfig()
+	if device.HTTPConfig != nil {
+		httpConfig = *device.HTTPConfig
+	}
+	httpClient, err := newHTTPClient(httpConfig, tlsClientConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		device:      device,
+		baseURL:     baseURL(device.Address, httpConfig),
+		httpClient:  httpClient,
+		concurrency: concurrency,
+		maxAge:      maxAge,
+		logins:      group[struct{}]{timeout: httpClient.Timeout},
+		gets:        group[[]byte]{timeout: httpClient.Timeout},
+	}, nil
+}
+
+// Device returns the device the client was created for
+func (c *Client) Device() config.Device {
+	return c.device
+}
+
+const (
+	SectionOLT      = "olt"
+	SectionONUs     = "onus"
+	SectionMACTable = "mac_table"
+)
+
+// Data holds the responses of all endpoints needed to export the metrics of a device
+type Data struct {
+	Statistics   *model.Statistics
+	Interfaces   *[]model.InterfacesInterface
+	ONUs         *[]model.ONU
+	ONUsSettings *[]model.ONUSettings
+	MACTable     *[]model.MACTable
+	// Sections contains all requested sections with their error, nil if the section was fetched successfully
+	Sections map[string]error
+	// Durations of the requests by endpoint
+	Durations map[string]time.Duration
+	// Errors of the failed requests by endpoint
+	Errors map[string]error
+}
+
+// Succeeded returns whether section was requested and fetched successfully
+func (d Data) Succeeded(section string) bool {
+	err, ok := d.Sections[section]
+	return ok && err == nil
+}
+
+// Available limits options to the sections that were fetched successfully
+func (d Data) Available(options config.Options) config.Options {
+	options.ExportOLT = options.ExportOLT && d.Succeeded(SectionOLT)
+	options.ExportONUs = options.ExportONUs && d.Succeeded(SectionONUs)
+	options.ExportMACTable = options.ExportMACTable && d.Succeeded(SectionMACTable)
+	return options
+}
+
+// FilterONUs returns a copy of the data, that contains only the ONUs for which keep returns true,
+// together with their settings and MAC table entries
+func (d Data) FilterONUs(keep func(onu model.ONU) bool) Data {
+	serials := map[string]bool{}
+	if d.ONUs != nil {
+		onus := []model.ONU{}
+		for _, onu := range *d.ONUs {
+			if keep(onu) {
+				onus = append(onus, onu)
+				serials[onu.Serial] = true
+			}
+		}
+		d.ONUs = &onus
+	}
+	if d.ONUsSettings != nil {
+		settings := []model.ONUSettings{}
+		for _, setting := range *d.ONUsSettings {
+			if serials[setting.Serial] {
+				settings = append(settings, setting)
+			}
+		}
+		d.ONUsSettings = &settings
+	}
+	if d.MACTable != nil {
+		macTable := []model.MACTable{}
+		for _, entry := range *d.MACTable {
+			if serials[entry.ONU] {
+				macTable = append(macTable, entry)
+			}
+		}
+		d.MACTable = &macTable
+	}
+	return d
+}
+
+// err joins the errors of all failed sections
+func (d Data) err() error {
+	var errs []error
+	for _, section := range []string{SectionOLT, SectionONUs, SectionMACTable} {
+		if err := d.Sections[section]; err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", section, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Fetch gets the sections required by options, each section can fail independently.
+// The returned error joins the errors of all failed sections, the data of the other sections is still valid.
+// After an authentication error the login is repeated and the requests retried once.
+func (c *Client) Fetch(ctx context.Context, log zerolog.Logger, options config.Options) (Data, error) {
+	data := c.fetch(ctx, log, options)
+	err := data.err()
+	if err != nil {
+		// only an expired or invalid session can be fixed by a retry, not a failed login
+		var authErr *AuthError
+		if !errors.As(err, &authErr) || authErr.Endpoint == "user/login" {
+			return data, err
+		}
+		log.Err(err).Msg("authentication error on first try")
+
+		// remove the rejected auth token, so login will be repeated
+		c.ResetToken(authErr.Token)
+		data = c.fetch(ctx, log, options)
+		err = data.err()
+		if err != nil {
+			return data, fmt.Errorf("error after retry: %w", err)
+		}
+	}
+
+	return data, nil
+}
+
+// job requests a single endpoint as part of fetch
+type job struct {
+	section  string
+	endpoint string
+	do       func(ctx context.Context) error
+}
+
+func (c *Client) fetch(ctx context.Context, log zerolog.Logger, options config.Options) Data {
+	data := Data{
+		Sections:  map[string]error{},
+		Durations: map[string]time.Duration{},
+		Errors:    map[string]error{},
+	}
+
+	var jobs []job
+	if options.ExportOLT {
+		data.Sections[SectionOLT] = nil
+		jobs = append(jobs, job{SectionOLT, "statistics", func(ctx context.Context) (err error) {
+			data.Statistics, err = c.GetStatistics(ctx, log)
+			return
+		}}, job{SectionOLT, "interfaces", func(ctx context.Context) (err error) {
+			data.Interfaces, err = c.GetInterfaces(ctx, log)
+			return
+		}})
+	}
+	if options.ExportONUs {
+		data.Sections[SectionONUs] = nil
+		jobs = append(jobs, job{SectionONUs, "gpon/onus", func(ctx context.Context) (err error) {
+			data.ONUs, err = c.GetONUs(ctx, log)
+			return
+		}}, job{SectionONUs, "gpon/onus/settings", func(ctx context.Context) (err error) {
+			data.ONUsSettings, err = c.GetONUsSettings(ctx, log)
+			return
+		}})
+	}
+	if options.ExportMACTable {
+		data.Sections[SectionMACTable] = nil
+		jobs = append(jobs, job{SectionMACTable, "tools/mac-table", func(ctx context.Context) (err error) {
+			data.MACTable, err = c.GetMACTable(ctx, log)
+			return
+		}})
+	}
+
+	// login before starting the parallel requests, so they share one session
+	_, err := c.Token(ctx, log)
+	if err != nil {
+		data.Errors["user/login"] = err
+		for section := range data.Sections {
+			data.Sections[section] = err
+		}
+		return data
+	}
+
+	// an authentication error is fatal for all sections, any other error only for its section
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sectionCtxs := map[string]context.Context{}
+	sectionCancels := map[string]context.CancelFunc{}
+	for section := range data.Sections {
+		sectionCtxs[section], sectionCancels[section] = context.WithCancel(ctx)
+		defer sectionCancels[section]()
+	}
+
+	var (
+		wg    sync.WaitGroup
+		mutex sync.Mutex
+	)
+	limit := make(chan struct{}, c.concurrency)
+	for _, j := range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx := sectionCtxs[j.section]
+
+			var err error
+			select {
+			case limit <- struct{}{}:
+				start := time.Now()
+				err = j.do(ctx)
+				duration := time.Since(start)
+				<-limit
+
+				mutex.Lock()
+				data.Durations[j.endpoint] = duration
+				if err != nil {
+					data.Errors[j.endpoint] = err
+				}
+				mutex.Unlock()
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+			if err == nil {
+				return
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			// the first error of a section cancels its other requests, their errors are only a consequence
+			if data.Sections[j.section] == nil {
+				data.Sections[j.section] = err
+				sectionCancels[j.section]()
+			}
+			var authErr *AuthError
+			if errors.As(err, &authErr) {
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return data
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/swoga/ufiber-exporter/model"
+)
+
+// StatusError is returned if the API responds with a non-200 status code
+type StatusError struct {
+	Endpoint   string
+	StatusCode int
+	// Response is the decoded error body of the OLT, nil if the body was no valid JSON
+	Response *model.ErrorResponse
+	Body     string
+}
+
+func (e *StatusError) Error() string {
+	msg := fmt.Sprintf("%s: non-200 response: %d", e.Endpoint, e.StatusCode)
+	if e.Response != nil && e.Response.Message != "" {
+		msg += ": " + e.Response.Message
+	}
+	return msg
+}
+
+// AuthError is returned if the login failed or the API rejected the session token
+type AuthError struct {
+	Endpoint string
+	// Token is the session token rejected by the API, empty if the login failed
+	Token string
+	// Response is the decoded body of the OLT, if there was one
+	Response *model.ErrorResponse
+	Err      error
+}
+
+func (e *AuthError) Error() string {
+	msg := fmt.Sprintf("%s: authentication failed", e.Endpoint)
+	if e.Response != nil && e.Response.Message != "" {
+		msg += ": " + e.Response.Message
+		if e.Response.Detail != "" {
+			msg += " (" + e.Response.Detail + ")"
+		}
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+// ServerError is returned if the API responds with a 5xx status code
+type ServerError struct {
+	Err *StatusError
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("server error: %s", e.Err)
+}
+
+func (e *ServerError) Unwrap() error {
+	return e.Err
+}
+
+// DecodeError is returned if the response of the API could not be decoded
+type DecodeError struct {
+	Endpoint string
+	Err      error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%s: error decoding response: %s", e.Endpoint, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
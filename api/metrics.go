@@ -0,0 +1,101 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "ufiber_exporter",
+		Name:      "api_request_duration_seconds",
+		Help:      "Duration of the requests to the API by endpoint.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"endpoint"})
+
+	requestErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ufiber_exporter",
+		Name:      "api_request_errors_total",
+		Help:      "Failed requests to the API by endpoint and error class.",
+	}, []string{"endpoint", "class"})
+
+	deduplicatedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ufiber_exporter",
+		Name:      "api_deduplicated_requests_total",
+		Help:      "Requests to the API that shared the response of a concurrent request.",
+	}, []string{"endpoint"})
+
+	logins = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "ufiber_exporter",
+		Name:      "api_logins_total",
+		Help:      "Logins because there was no session token.",
+	})
+
+	relogins = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "ufiber_exporter",
+		Name:      "api_relogins_total",
+		Help:      "Logins because the previous session token was rejected.",
+	})
+
+	certificateExpiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "ufiber_exporter",
+		Name:      "tls_certificate_expiry_timestamp_seconds",
+		Help:      "Expiry of the certificate presented by the device.",
+	}, []string{"address"})
+
+	fingerprintMismatches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ufiber_exporter",
+		Name:      "tls_fingerprint_mismatches_total",
+		Help:      "Connections rejected because the certificate did not match the pinned or recorded fingerprint.",
+	}, []string{"address"})
+)
+
+func init() {
+	prometheus.MustRegister(requestDuration)
+	prometheus.MustRegister(requestErrors)
+	prometheus.MustRegister(deduplicatedRequests)
+	prometheus.MustRegister(logins)
+	prometheus.MustRegister(relogins)
+	prometheus.MustRegister(certificateExpiry)
+	prometheus.MustRegister(fingerprintMismatches)
+}
+
+// ErrorClass returns a short class of err for use as metric label
+func ErrorClass(err error) string {
+	var authErr *AuthError
+	var serverErr *ServerError
+	var statusErr *StatusError
+	var decodeErr *DecodeError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &authErr):
+		return "auth"
+	case errors.As(err, &serverErr):
+		return "server"
+	case errors.As(err, &statusErr):
+		return "status"
+	case errors.As(err, &decodeErr):
+		return "decode"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.As(err, &netErr):
+		if netErr.Timeout() {
+			return "timeout"
+		}
+		return "network"
+	}
+	return "other"
+}
+
+func observeRequest(endpoint string, start time.Time, err error) {
+	requestDuration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+	if err != nil {
+		requestErrors.WithLabelValues(endpoint, ErrorClass(err)).Inc()
+	}
+}
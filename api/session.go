@@ -0,0 +1,126 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Session is the login state of a client
+type Session struct {
+	Token  string    `json:"token"`
+	Issued time.Time `json:"issued"`
+}
+
+// valid returns whether the session has a token that is younger than maxAge, 0 disables the age check
+func (s Session) valid(maxAge time.Duration) bool {
+	if s.Token == "" {
+		return false
+	}
+	return maxAge <= 0 || time.Since(s.Issued) < maxAge
+}
+
+// Session returns the current session of the client
+func (c *Client) Session() Session {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.session
+}
+
+// SetSession replaces the session of the client, e.g. to restore a persisted session
+func (c *Client) SetSession(session Session) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.session = session
+	c.expired = false
+}
+
+// Token returns the current session token, a login is done if there is none or it exceeded the max age
+func (c *Client) Token(ctx context.Context, log zerolog.Logger) (string, error) {
+	session := c.Session()
+	if session.valid(c.maxAge) {
+		return session.Token, nil
+	}
+
+	if session.Token != "" {
+		log.Debug().Time("issued", session.Issued).Msg("session exceeded max age, login again")
+	}
+
+	// concurrent requests without a session share one login
+	_, err := c.logins.do(ctx, "", func(ctx context.Context) (struct{}, error) {
+		previous := c.Session()
+		// a login that finished since the check above already renewed the session
+		if previous.valid(c.maxAge) {
+			return struct{}{}, nil
+		}
+		logins.Inc()
+		if c.isExpired() {
+			relogins.Inc()
+		}
+		err := c.Login(ctx, log)
+		if err != nil {
+			return struct{}{}, err
+		}
+		// the session that exceeded the max age is still valid on the device, end it so it does not leak
+		if previous.Token != "" {
+			err := c.logout(ctx, log, previous.Token)
+			if err != nil {
+				log.Warn().Err(err).Msg("error logging out session that exceeded max age")
+			}
+		}
+		return struct{}{}, nil
+	}, func() {
+		log.Debug().Msg("shared in-flight login")
+		deduplicatedRequests.WithLabelValues("user/login").Inc()
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return c.Session().Token, nil
+}
+
+func (c *Client) isExpired() bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.expired
+}
+
+// ResetToken drops the current session if its token is the rejected token, so the next request does a new login.
+// A session issued while the request with the rejected token was in flight is kept.
+func (c *Client) ResetToken(token string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if token == "" || c.session.Token != token {
+		return
+	}
+	c.expired = true
+	c.session = Session{}
+}
+
+// Logout ends the current session on the device, nothing is done if there is no session
+func (c *Client) Logout(ctx context.Context, log zerolog.Logger) (err error) {
+	c.mutex.Lock()
+	token := c.session.Token
+	c.session = Session{}
+	c.mutex.Unlock()
+	if token == "" {
+		return nil
+	}
+	return c.logout(ctx, log, token)
+}
+
+// logout ends the session of token on the device
+func (c *Client) logout(ctx context.Context, log zerolog.Logger, token string) (err error) {
+	start := time.Now()
+	defer func() { observeRequest("user/logout", start, err) }()
+
+	res, err := c.request(ctx, log, token, "POST", "user/logout", nil)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// call is an in-flight or completed group.do call
+type call[T any] struct {
+	done chan struct{}
+	val  T
+	err  error
+}
+
+// group coalesces concurrent calls with the same key into one execution
+type group[T any] struct {
+	// timeout limits a call, as it is not canceled with the context of its callers, 0 disables the limit
+	timeout time.Duration
+
+	mutex sync.Mutex
+	calls map[string]*call[T]
+}
+
+// do executes fn, if there is already a call for key in-flight it is joined instead and joined is called.
+// fn runs with a context that is detached from ctx, so a caller that gives up does not fail the others,
+// each caller only stops waiting when its own ctx is done.
+func (g *group[T]) do(ctx context.Context, key string, fn func(ctx context.Context) (T, error), joined func()) (T, error) {
+	g.mutex.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*call[T]{}
+	}
+	c, ok := g.calls[key]
+	if ok {
+		g.mutex.Unlock()
+		if joined != nil {
+			joined()
+		}
+	} else {
+		c = &call[T]{done: make(chan struct{})}
+		g.calls[key] = c
+		g.mutex.Unlock()
+
+		go func() {
+			callCtx := context.WithoutCancel(ctx)
+			if g.timeout > 0 {
+				var cancel context.CancelFunc
+				callCtx, cancel = context.WithTimeout(callCtx, g.timeout)
+				defer cancel()
+			}
+
+			c.val, c.err = fn(callCtx)
+
+			g.mutex.Lock()
+			delete(g.calls, key)
+			g.mutex.Unlock()
+			close(c.done)
+		}()
+	}
+
+	select {
+	case <-c.done:
+		return c.val, c.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestGroupCanceledCaller(t *testing.T) {
+	var g group[string]
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (string, error) {
+		<-release
+		return "response", ctx.Err()
+	}
+
+	// the caller that started the call gives up
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan error)
+	go func() {
+		_, err := g.do(ctx, "key", fn, nil)
+		started <- err
+	}()
+
+	joined := make(chan struct{})
+	result := make(chan error)
+	go func() {
+		// wait for the first call to be in-flight
+		for {
+			g.mutex.Lock()
+			_, ok := g.calls["key"]
+			g.mutex.Unlock()
+			if ok {
+				break
+			}
+		}
+		val, err := g.do(context.Background(), "key", fn, func() { close(joined) })
+		if err == nil && val != "response" {
+			err = errors.New("unexpected response " + val)
+		}
+		result <- err
+	}()
+
+	<-joined
+	cancel()
+	if err := <-started; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected canceled caller to stop waiting, got %v", err)
+	}
+	close(release)
+	if err := <-result; err != nil {
+		t.Errorf("expected joined caller to get the response, got %v", err)
+	}
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/swoga/ufiber-exporter/config"
+)
+
+var (
+	fingerprintStoresMutex sync.Mutex
+	fingerprintStores      = map[string]*fingerprintStore{}
+)
+
+// fingerprintStore records the certificate fingerprints of trust on first use devices in a file
+type fingerprintStore struct {
+	mutex        sync.Mutex
+	file         string
+	fingerprints map[string]string
+}
+
+// getFingerprintStore returns the store of file, all clients using the same file share one store
+func getFingerprintStore(file string) (*fingerprintStore, error) {
+	fingerprintStoresMutex.Lock()
+	defer fingerprintStoresMutex.Unlock()
+
+	store, ok := fingerprintStores[file]
+	if ok {
+		return store, nil
+	}
+
+	store = &fingerprintStore{
+		file:         file,
+		fingerprints: map[string]string{},
+	}
+	data, err := os.ReadFile(file)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &store.fingerprints)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing fingerprint file: %w", err)
+		}
+	}
+
+	fingerprintStores[file] = store
+	return store, nil
+}
+
+// verify compares fingerprint with the recorded one of address, the first seen fingerprint is recorded
+func (s *fingerprintStore) verify(address string, fingerprint string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	known, ok := s.fingerprints[address]
+	if ok {
+		if known != fingerprint {
+			return fmt.Errorf("certificate fingerprint %s does not match recorded %s", fingerprint, known)
+		}
+		return nil
+	}
+
+	s.fingerprints[address] = fingerprint
+	data, err := json.MarshalIndent(s.fingerprints, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.file, data, 0o600)
+}
+
+// normalizeFingerprint returns the lower case hex fingerprint without separators
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.ReplaceAll(fingerprint, ":", ""))
+}
+
+// newTLSConfig builds the TLS config of a device.
+// A pinned fingerprint or trust on first use replaces the verification of the certificate chain.
+func newTLSConfig(address string, tlsConfig config.TLSConfig) (*tls.Config, error) {
+	c := &tls.Config{
+		InsecureSkipVerify: tlsConfig.InsecureSkipVerify,
+		ServerName:         tlsConfig.ServerName,
+	}
+
+	if tlsConfig.CAFile != "" {
+		ca, err := os.ReadFile(tlsConfig.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA file: %w", err)
+		}
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", tlsConfig.CAFile)
+		}
+	}
+
+	var store *fingerprintStore
+	if tlsConfig.TrustOnFirstUse {
+		if tlsConfig.FingerprintFile == "" {
+			return nil, errors.New("trust_on_first_use requires a fingerprint_file")
+		}
+		var err error
+		store, err = getFingerprintStore(tlsConfig.FingerprintFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	pinned := normalizeFingerprint(tlsConfig.Fingerprint)
+	if pinned != "" || store != nil {
+		c.InsecureSkipVerify = true
+	}
+
+	c.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("no peer certificate")
+		}
+		leaf := cs.PeerCertificates[0]
+		certificateExpiry.WithLabelValues(address).Set(float64(leaf.NotAfter.Unix()))
+
+		sum := sha256.Sum256(leaf.Raw)
+		fingerprint := hex.EncodeToString(sum[:])
+
+		var err error
+		switch {
+		case pinned != "":
+			if fingerprint != pinned {
+				err = fmt.Errorf("certificate fingerprint %s does not match pinned %s", fingerprint, pinned)
+			}
+		case store != nil:
+			err = store.verify(address, fingerprint)
+		}
+		if err != nil {
+			fingerprintMismatches.WithLabelValues(address).Inc()
+		}
+		return err
+	}
+
+	return c, nil
+}
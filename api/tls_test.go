@@ -0,0 +1,110 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/swoga/ufiber-exporter/config"
+	"github.com/swoga/ufiber-exporter/fakeolt"
+)
+
+func loginWithTLSConfig(t *testing.T, tlsConfig config.TLSConfig) (string, error) {
+	t.Helper()
+
+	olt, err := fakeolt.New("../testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := olt.NewTLSServer()
+	t.Cleanup(ts.Close)
+
+	sum := sha256.Sum256(ts.Certificate().Raw)
+	fingerprint := hex.EncodeToString(sum[:])
+
+	if tlsConfig.Fingerprint == "pinned" {
+		tlsConfig.Fingerprint = fingerprint
+	}
+	if tlsConfig.CAFile == "ca" {
+		tlsConfig.CAFile = filepath.Join(t.TempDir(), "ca.pem")
+		ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+		err := os.WriteFile(tlsConfig.CAFile, ca, 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	username, password := "ubnt", config.Secret("ubnt")
+	client, err := NewClient(config.Device{
+		Address:   ts.Listener.Addr().String(),
+		Username:  &username,
+		Password:  &password,
+		TLSConfig: &tlsConfig,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return fingerprint, client.Login(context.Background(), zerolog.Nop())
+}
+
+func TestTLSVerify(t *testing.T) {
+	_, err := loginWithTLSConfig(t, config.TLSConfig{})
+	if err == nil {
+		t.Error("expected unknown certificate to be rejected")
+	}
+
+	_, err = loginWithTLSConfig(t, config.TLSConfig{CAFile: "ca", ServerName: "example.com"})
+	if err != nil {
+		t.Errorf("expected certificate to be trusted by CA file: %s", err)
+	}
+}
+
+func TestTLSFingerprint(t *testing.T) {
+	_, err := loginWithTLSConfig(t, config.TLSConfig{Fingerprint: "pinned"})
+	if err != nil {
+		t.Errorf("expected pinned fingerprint to match: %s", err)
+	}
+
+	_, err = loginWithTLSConfig(t, config.TLSConfig{Fingerprint: "00:11:22"})
+	if err == nil {
+		t.Error("expected fingerprint mismatch")
+	}
+}
+
+func TestTLSTrustOnFirstUse(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "fingerprints.json")
+	tlsConfig := config.TLSConfig{TrustOnFirstUse: true, FingerprintFile: file}
+
+	fingerprint, err := loginWithTLSConfig(t, tlsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := getFingerprintStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.verify("olt", fingerprint); err != nil {
+		t.Errorf("expected first fingerprint to be recorded: %s", err)
+	}
+	if err := store.verify("olt", fingerprint); err != nil {
+		t.Errorf("expected recorded fingerprint to match: %s", err)
+	}
+	if err := store.verify("olt", "other"); err == nil {
+		t.Error("expected changed fingerprint to be rejected")
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) == 0 {
+		t.Error("expected fingerprints to be written")
+	}
+}
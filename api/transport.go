@@ -0,0 +1,69 @@
+package api
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/swoga/ufiber-exporter/config"
+)
+
+// newHTTPClient creates the HTTP client with its own connection pool for a device
+func newHTTPClient(httpConfig config.HTTPConfig, tlsClientConfig *tls.Config) (*http.Client, error) {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if httpConfig.SourceAddress != "" {
+		ip := net.ParseIP(httpConfig.SourceAddress)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid source_address: %s", httpConfig.SourceAddress)
+		}
+		dialer.LocalAddr = &net.TCPAddr{IP: ip}
+	}
+
+	transport := &http.Transport{
+		DialContext:         dialer.DialContext,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 5,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+		TLSClientConfig:     tlsClientConfig,
+	}
+	if httpConfig.ProxyURL != "" {
+		proxyURL, err := url.Parse(httpConfig.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy_url: %w", err)
+		}
+		// http, https and socks5 proxies are supported by the transport
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   time.Duration(httpConfig.RequestTimeout * float64(time.Second)),
+	}, nil
+}
+
+// baseURL returns the URL all endpoints of the API are relative to
+func baseURL(address string, httpConfig config.HTTPConfig) string {
+	host := address
+	if httpConfig.Port != 0 {
+		// the port of the address takes precedence
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			host = net.JoinHostPort(strings.Trim(address, "[]"), strconv.Itoa(httpConfig.Port))
+		}
+	}
+
+	basePath := "/" + strings.Trim(httpConfig.BasePath, "/") + "/"
+	if basePath == "//" {
+		basePath = "/"
+	}
+
+	return fmt.Sprintf("%s://%s%s", httpConfig.Scheme, host, basePath)
+}
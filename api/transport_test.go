@@ -0,0 +1,92 @@
+package api
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"net/http/httputil"
+	"sync/atomic"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/swoga/ufiber-exporter/config"
+	"github.com/swoga/ufiber-exporter/fakeolt"
+)
+
+func TestBaseURL(t *testing.T) {
+	tests := []struct {
+		address    string
+		httpConfig config.HTTPConfig
+		want       string
+	}{
+		{"olt", config.DefaultHTTPConfig(), "https://olt/api/v1.0/"},
+		{"olt", config.HTTPConfig{Scheme: "http", Port: 8080, BasePath: "api/v1.0"}, "http://olt:8080/api/v1.0/"},
+		{"olt:8443", config.HTTPConfig{Scheme: "https", Port: 8080, BasePath: "/"}, "https://olt:8443/"},
+		{"2001:db8::1", config.HTTPConfig{Scheme: "https", Port: 443, BasePath: "/x/"}, "https://[2001:db8::1]:443/x/"},
+		{"[2001:db8::1]", config.HTTPConfig{Scheme: "https", Port: 443, BasePath: ""}, "https://[2001:db8::1]:443/"},
+	}
+
+	for _, test := range tests {
+		got := baseURL(test.address, test.httpConfig)
+		if got != test.want {
+			t.Errorf("baseURL(%q) = %q, want %q", test.address, got, test.want)
+		}
+	}
+}
+
+func TestHTTPConfig(t *testing.T) {
+	olt, err := fakeolt.New("../testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(olt)
+	defer ts.Close()
+
+	// forward proxy counting the requests passing through
+	var proxied atomic.Int32
+	proxy := httptest.NewServer(&httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			proxied.Add(1)
+			r.Out.URL = r.In.URL
+		},
+	})
+	defer proxy.Close()
+
+	username, password := "ubnt", config.Secret("ubnt")
+	client, err := NewClient(config.Device{
+		Address:  "127.0.0.1",
+		Username: &username,
+		Password: &password,
+		HTTPConfig: &config.HTTPConfig{
+			Scheme:         "http",
+			Port:           ts.Listener.Addr().(*net.TCPAddr).Port,
+			BasePath:       "/api/v1.0",
+			ProxyURL:       proxy.URL,
+			SourceAddress:  "127.0.0.1",
+			RequestTimeout: 10,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.GetONUs(context.Background(), zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := proxied.Load(); n != 2 {
+		t.Errorf("expected login and request through proxy, got %d requests", n)
+	}
+}
+
+func TestHTTPConfigInvalid(t *testing.T) {
+	for _, httpConfig := range []config.HTTPConfig{
+		{Scheme: "https", SourceAddress: "not-an-ip"},
+		{Scheme: "https", ProxyURL: "://proxy"},
+	} {
+		_, err := NewClient(config.Device{Address: "olt", HTTPConfig: &httpConfig})
+		if err == nil {
+			t.Errorf("expected error for %+v", httpConfig)
+		}
+	}
+}
@@ -1,36 +1,106 @@
 package cache
 
-import "sync"
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+)
 
-type Cache struct {
-	values map[string]string
+type Cache[V any] struct {
+	values map[string]V
 	mutex  sync.RWMutex
 }
 
-func New() Cache {
-	return Cache{
-		values: map[string]string{},
+func New[V any]() Cache[V] {
+	return Cache[V]{
+		values: map[string]V{},
 	}
 }
 
-func (c *Cache) Get(key string) string {
+func (c *Cache[V]) Get(key string) V {
 	c.mutex.RLock()
 	defer c.mutex.RUnlock()
 	value, ok := c.values[key]
 	if !ok {
-		return ""
+		var zero V
+		return zero
 	}
 	return value
 }
 
-func (c *Cache) Set(key string, value string) {
+// GetOrCreate returns the value of key, if there is none it is created and stored atomically
+func (c *Cache[V]) GetOrCreate(key string, create func() (V, error)) (V, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	value, ok := c.values[key]
+	if !ok {
+		var err error
+		value, err = create()
+		if err != nil {
+			return value, err
+		}
+		c.values[key] = value
+	}
+	return value, nil
+}
+
+func (c *Cache[V]) Set(key string, value V) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 	c.values[key] = value
 }
 
-func (c *Cache) Remove(key string) {
+func (c *Cache[V]) Remove(key string) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 	delete(c.values, key)
 }
+
+func (c *Cache[V]) Clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.values = map[string]V{}
+}
+
+// Items returns a copy of all values
+func (c *Cache[V]) Items() map[string]V {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	items := make(map[string]V, len(c.values))
+	for key, value := range c.values {
+		items[key] = value
+	}
+	return items
+}
+
+// Load replaces all values with the JSON encoded values in file, a missing file is no error
+func (c *Cache[V]) Load(file string) error {
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	values := map[string]V{}
+	err = json.Unmarshal(data, &values)
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.values = values
+	return nil
+}
+
+// Save writes all values JSON encoded to file, only readable by the owner
+func (c *Cache[V]) Save(file string) error {
+	data, err := json.Marshal(c.Items())
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, 0o600)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"net"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/swoga/ufiber-exporter/fakeolt"
+)
+
+func main() {
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339})
+
+	// parse command line args
+	listen := flag.String("listen", "127.0.0.1:8443", "")
+	fixtures := flag.String("fixtures", "testdata", "")
+	username := flag.String("username", "ubnt", "")
+	password := flag.String("password", "ubnt", "")
+	tokenTTL := flag.Duration("token-ttl", 0, "")
+	latency := flag.Duration("latency", 0, "")
+	errors := flag.String("errors", "", "comma separated list of endpoint=status_code")
+	flag.Parse()
+
+	server, err := fakeolt.New(*fixtures)
+	if err != nil {
+		log.Panic().Err(err).Msg("error loading fixtures")
+	}
+	server.Username = *username
+	server.Password = *password
+	server.TokenTTL = *tokenTTL
+	server.SetLatency("", *latency)
+
+	if *errors != "" {
+		for _, entry := range strings.Split(*errors, ",") {
+			endpoint, code, ok := strings.Cut(entry, "=")
+			if !ok {
+				log.Panic().Str("entry", entry).Msg("invalid error entry")
+			}
+			statusCode, err := strconv.Atoi(code)
+			if err != nil {
+				log.Panic().Err(err).Str("entry", entry).Msg("invalid status code")
+			}
+			server.SetError(endpoint, statusCode)
+		}
+	}
+
+	listener, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Panic().Err(err).Msg("error listening")
+	}
+
+	// httptest provides a self-signed certificate, which is enough for a fake
+	ts := httptest.NewUnstartedServer(server)
+	ts.Listener.Close()
+	ts.Listener = listener
+	ts.StartTLS()
+	defer ts.Close()
+
+	log.Info().Str("url", ts.URL).Str("fixtures", *fixtures).Msg("started fake OLT")
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+}
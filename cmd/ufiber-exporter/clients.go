@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/swoga/ufiber-exporter/api"
+	"github.com/swoga/ufiber-exporter/cache"
+	"github.com/swoga/ufiber-exporter/config"
+)
+
+var (
+	clientCache = cache.New[*api.Client]()
+	// sessionCache holds the sessions restored from the token file, until a client for the target is created
+	sessionCache = cache.New[savedSession]()
+)
+
+// savedSession is a session in the token file
+type savedSession struct {
+	api.Session
+	// Address of the device, to log out the session after the device was removed
+	Address string `json:"address,omitempty"`
+}
+
+// getClient returns the cached API client for target, or creates a new one
+func getClient(target string, device config.Device) (*api.Client, error) {
+	return clientCache.GetOrCreate(target, func() (*api.Client, error) {
+		client, err := api.NewClient(device)
+		if err != nil {
+			return nil, err
+		}
+		if session := sessionCache.Get(target); session.Token != "" {
+			client.SetSession(session.Session)
+			sessionCache.Remove(target)
+		}
+		return client, nil
+	})
+}
+
+// globalDevice returns the device used for a target that is not configured
+func globalDevice(conf *config.Config, target string) *config.Device {
+	return &config.Device{
+		Address:           target,
+		Username:          &conf.Global.Username,
+		Password:          &conf.Global.Password,
+		Options:           &conf.Global.Options,
+		PollInterval:      &conf.Global.PollInterval,
+		Concurrency:       &conf.Global.Concurrency,
+		TokenMaxAge:       &conf.Global.TokenMaxAge,
+		TLSConfig:         &conf.Global.TLSConfig,
+		HTTPConfig:        &conf.Global.HTTPConfig,
+		Timeout:           &conf.Timeout,
+		Labels:            conf.Global.Labels,
+		DiscoverONUs:      &conf.Global.DiscoverONUs,
+		LegacyMetricNames: &conf.Global.LegacyMetricNames,
+	}
+}
+
+// reloadClients removes and logs out all clients whose device was removed or changed in conf
+func reloadClients(log zerolog.Logger, conf *config.Config) {
+	dropRestoredSessions(log, conf)
+	for target, client := range clientCache.Items() {
+		device, ok := conf.GetDevice(target)
+		if !ok {
+			device = globalDevice(conf, target)
+		}
+		if reflect.DeepEqual(*device, client.Device()) {
+			continue
+		}
+
+		clientCache.Remove(target)
+		go logoutClient(log.With().Str("target", target).Logger(), client)
+	}
+}
+
+// dropRestoredSessions logs out the sessions restored from the token file, whose device is not configured in conf anymore,
+// otherwise they would be saved again on every shutdown
+func dropRestoredSessions(log zerolog.Logger, conf *config.Config) {
+	for target, session := range sessionCache.Items() {
+		if _, ok := conf.GetDevice(target); ok {
+			continue
+		}
+		sessionCache.Remove(target)
+
+		address := session.Address
+		if address == "" {
+			address = target
+		}
+		targetLog := log.With().Str("target", target).Logger()
+		client, err := api.NewClient(*globalDevice(conf, address))
+		if err != nil {
+			targetLog.Err(err).Msg("error creating client to log out restored session")
+			continue
+		}
+		client.SetSession(session.Session)
+		go logoutClient(targetLog, client)
+	}
+}
+
+// shutdownClients saves the sessions of all clients to the token file, or logs them out if there is none
+func shutdownClients(log zerolog.Logger, conf *config.Config) {
+	clients := clientCache.Items()
+
+	if conf.TokenFile != "" {
+		for target, client := range clients {
+			sessionCache.Set(target, savedSession{Session: client.Session(), Address: client.Device().Address})
+		}
+		err := sessionCache.Save(conf.TokenFile)
+		if err != nil {
+			log.Err(err).Str("token_file", conf.TokenFile).Msg("error saving sessions")
+		}
+		return
+	}
+
+	var wg sync.WaitGroup
+	for target, client := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			logoutClient(log.With().Str("target", target).Logger(), client)
+		}()
+	}
+	wg.Wait()
+}
+
+func logoutClient(log zerolog.Logger, client *api.Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := client.Logout(ctx, log)
+	if err != nil {
+		log.Err(err).Msg("error logging out")
+		return
+	}
+	log.Debug().Msg("logged out")
+}
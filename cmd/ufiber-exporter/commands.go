@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/swoga/ufiber-exporter/config"
+)
+
+// commands are the subcommands available as first argument, without one the exporter is started
+var commands = map[string]func(args []string, out io.Writer) int{
+	"check-config": checkConfig,
+	"write-sd":     writeSD,
+}
+
+// checkConfig loads and validates the config file without starting the exporter
+func checkConfig(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("check-config", flag.ContinueOnError)
+	flags.SetOutput(out)
+	configFile := flags.String("config.file", "config.yml", "")
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+
+	c, err := config.Load(*configFile)
+	if err != nil {
+		fmt.Fprintf(out, "FAILED: %s\n", err)
+		return 1
+	}
+
+	fmt.Fprintf(out, "SUCCESS: %s is valid, %d devices configured\n", *configFile, len(c.Devices))
+	return 0
+}
+
+// writeSD writes the configured devices as file for file_sd_config
+func writeSD(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("write-sd", flag.ContinueOnError)
+	flags.SetOutput(out)
+	configFile := flags.String("config.file", "config.yml", "")
+	output := flags.String("output", "", "")
+	address := flags.String("address", "", "")
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+
+	c, err := config.Load(*configFile)
+	if err != nil {
+		fmt.Fprintf(out, "FAILED: %s\n", err)
+		return 1
+	}
+	if *address == "" {
+		*address = exporterAddress(c.Listen)
+	}
+
+	data, err := json.MarshalIndent(sdTargets(c, *address, nil), "", "  ")
+	if err != nil {
+		fmt.Fprintf(out, "FAILED: %s\n", err)
+		return 1
+	}
+	data = append(data, '\n')
+
+	if *output == "" {
+		out.Write(data)
+		return 0
+	}
+	err = writeFile(*output, data)
+	if err != nil {
+		fmt.Fprintf(out, "FAILED: %s\n", err)
+		return 1
+	}
+	fmt.Fprintf(out, "SUCCESS: wrote %d devices to %s\n", len(c.Devices), *output)
+	return 0
+}
@@ -5,11 +5,13 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
+	"sync"
 	"syscall"
 	"time"
 
@@ -19,7 +21,6 @@ import (
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/swoga/ufiber-exporter/api"
-	"github.com/swoga/ufiber-exporter/cache"
 	"github.com/swoga/ufiber-exporter/collector"
 	"github.com/swoga/ufiber-exporter/config"
 	"github.com/swoga/ufiber-exporter/model"
@@ -28,17 +29,34 @@ import (
 var (
 	version       = "dev"
 	sc            config.SafeConfig
-	authCache     = cache.New()
+	polls         = newPoller()
 	consoleWriter = zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339}
+
+	configReloadAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ufiber_exporter",
+		Name:      "config_reload_attempts_total",
+		Help:      "Attempts to reload the config file by trigger.",
+	}, []string{"trigger"})
 )
 
 func main() {
 	log.Logger = log.Output(consoleWriter)
+
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			log.Logger = log.Logger.Level(zerolog.InfoLevel)
+			os.Exit(command(os.Args[2:], os.Stdout))
+		}
+	}
+
 	log.Info().Str("version", version).Msg("starting ufiber-exporter")
 
 	// parse command line args
 	configFile := flag.String("config.file", "config.yml", "")
 	debug := flag.Bool("debug", false, "")
+	watch := flag.Bool("config.watch", false, "")
+	watchInterval := flag.Duration("config.watch-interval", 5*time.Second, "")
+	watchDebounce := flag.Duration("config.watch-debounce", 2*time.Second, "")
 	flag.Parse()
 
 	if *debug {
@@ -53,26 +71,57 @@ func main() {
 	if err != nil {
 		log.Panic().Err(err).Msg("error loading config")
 	}
+	if tokenFile := sc.Get().TokenFile; tokenFile != "" {
+		err = sessionCache.Load(tokenFile)
+		if err != nil {
+			log.Err(err).Str("token_file", tokenFile).Msg("error loading sessions")
+		}
+		dropRestoredSessions(log.Logger, sc.Get())
+	}
+	prometheus.MustRegister(polls)
+	prometheus.MustRegister(configReloadAttempts)
+	polls.update(sc.Get())
 
 	// setup config reload
 	hup := make(chan os.Signal, 1)
 	signal.Notify(hup, syscall.SIGHUP)
 	reloadRequest := make(chan chan error)
+	fileChanged := make(chan struct{}, 1)
+	if *watch {
+		w := newWatcher(*watchInterval, *watchDebounce, func() []string {
+			return sc.Get().WatchFiles()
+		})
+		go w.run(context.Background(), func() {
+			select {
+			case fileChanged <- struct{}{}:
+			default:
+			}
+		})
+	}
 	go func() {
 		for {
 			var err error
 			select {
 			case <-hup:
 				log.Debug().Msg("config reload triggerd by SIGHUP")
+				configReloadAttempts.WithLabelValues("signal").Inc()
 				err = sc.LoadConfig()
 			case reloadResult := <-reloadRequest:
 				log.Debug().Msg("config reload triggerd by API")
+				configReloadAttempts.WithLabelValues("api").Inc()
 				err = sc.LoadConfig()
 				reloadResult <- err
+			case <-fileChanged:
+				log.Debug().Msg("config reload triggerd by file change")
+				configReloadAttempts.WithLabelValues("watch").Inc()
+				err = sc.LoadConfig()
 			}
 			if err != nil {
 				log.Err(err).Msg("error reloading config")
 			} else {
+				// devices may have changed, end their sessions and start with new clients
+				reloadClients(log.Logger, sc.Get())
+				polls.update(sc.Get())
 				log.Info().Msg("reloaded config file")
 			}
 		}
@@ -87,17 +136,33 @@ func main() {
 		}
 	})
 
+	http.HandleFunc("/sd", handleSD)
+
 	// start http server
 	config := sc.Get()
-	http.Handle(config.MetricsPath, promhttp.Handler())
+	// the metrics path serves the polled snapshots next to the metrics of the exporter
+	metricsHandler := promhttp.HandlerFor(prometheus.Gatherers{prometheus.DefaultGatherer, polls}, promhttp.HandlerOpts{})
+	http.Handle(config.MetricsPath, promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, metricsHandler))
 	http.HandleFunc(config.ProbePath, handleRequest)
 
 	log.Info().Str("metrics_path", config.MetricsPath).Str("listen", config.Listen).Str("probe_path", config.ProbePath).Msg("starting http server")
 
-	err = http.ListenAndServe(config.Listen, nil)
-	if err != nil {
+	server := &http.Server{Addr: config.Listen}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-stop
+		log.Info().Msg("shutting down")
+		server.Shutdown(context.Background())
+	}()
+
+	err = server.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Panic().Err(err).Msg("error starting http server")
 	}
+
+	polls.stop()
+	shutdownClients(log.Logger, sc.Get())
 }
 
 func handleRequest(w http.ResponseWriter, r *http.Request) {
@@ -114,8 +179,11 @@ func handleRequest(w http.ResponseWriter, r *http.Request) {
 
 	requestLog := log.With().Logger()
 
+	var debugOut *syncWriter
 	if debug || trace {
-		debugWriter := zerolog.ConsoleWriter{Out: w, TimeFormat: time.RFC3339, NoColor: true}
+		debugOut = &syncWriter{w: w}
+		defer debugOut.Close()
+		debugWriter := zerolog.ConsoleWriter{Out: debugOut, TimeFormat: time.RFC3339, NoColor: true}
 		multi := zerolog.MultiLevelWriter(consoleWriter, debugWriter)
 		requestLog = requestLog.Output(multi)
 		w.Header().Set("Content-Type", "text/plain")
@@ -141,15 +209,28 @@ func handleRequest(w http.ResponseWriter, r *http.Request) {
 		}
 
 		requestLog.Debug().Msg("unconfigured target, use param as address")
-		device = &config.Device{
-			Address:  target,
-			Username: &conf.Global.Username,
-			Password: &conf.Global.Password,
-			Options:  &conf.Global.Options,
-		}
+		device = globalDevice(conf, target)
 	}
 
 	deviceOptions := *device.Options
+	timeout := *device.Timeout
+	var onuFilter, ponFilter string
+
+	paramProfile := r.URL.Query().Get("profile")
+	if paramProfile != "" {
+		profile, ok := conf.GetProfile(paramProfile)
+		if !ok {
+			requestLog.Error().Str("profile", paramProfile).Msg("unknown profile")
+			http.Error(w, "unknown profile", http.StatusBadRequest)
+			return
+		}
+		deviceOptions = profile.Options.Apply(deviceOptions)
+		if profile.Timeout != nil {
+			timeout = *profile.Timeout
+		}
+		onuFilter = profile.ONU
+		ponFilter = profile.PON
+	}
 
 	paramExportOLT := r.URL.Query().Get("export_olt")
 	if paramExportOLT != "" {
@@ -164,7 +245,7 @@ func handleRequest(w http.ResponseWriter, r *http.Request) {
 		deviceOptions.ExportMACTable = paramExportMACTable == "1"
 	}
 
-	timeout := getTimeout(conf, r)
+	timeout = getTimeout(timeout, r)
 
 	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(timeout*float64(time.Second)))
 	defer cancel()
@@ -172,165 +253,259 @@ func handleRequest(w http.ResponseWriter, r *http.Request) {
 
 	start := time.Now()
 	registry := prometheus.NewRegistry()
+	// all metrics of the probe get the labels of the device
+	deviceRegistry := prometheus.WrapRegistererWith(device.Labels, registry)
+
+	exporterRegistry := prometheus.WrapRegistererWithPrefix("ufiber_exporter_", deviceRegistry)
+	addDeviceInfoMetric(target, *device, exporterRegistry)
 
 	var success float64 = 1
 
-	data, err := getFromAPIWithRetry(ctx, requestLog, target, *device, deviceOptions)
-	if err != nil {
-		if errors.Is(err, context.Canceled) {
-			return
+	var data api.Data
+	snap, polled, err := polls.get(target)
+	if polled {
+		// serve the latest snapshot of the background polling
+		requestLog.Debug().Time("snapshot", snap.time).Msg("use polled snapshot")
+		var snapErr error
+		data, snapErr = snap.dataFor(deviceOptions)
+		if err == nil {
+			err = snapErr
 		}
+		addSnapshotMetrics(snap, exporterRegistry)
+	} else {
+		var client *api.Client
+		client, err = getClient(target, *device)
+		if err == nil {
+			data, err = client.Fetch(ctx, requestLog, deviceOptions)
+		}
+	}
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return
+	}
+	addRequestMetrics(data, exporterRegistry)
+	if err != nil {
 		requestLog.Err(err).Msg("error getting data from API")
 		success = 0
-	} else {
-		exporterRegistry := prometheus.WrapRegistererWithPrefix("ufiber_exporter_", registry)
-		err = addMetrics(data, deviceOptions, exporterRegistry)
-		if err != nil {
-			requestLog.Err(err).Msg("error adding metrics")
+	}
+
+	// limit the ONUs to a single ONU or PON, to scrape them as own targets
+	if paramONU := r.URL.Query().Get("onu"); paramONU != "" {
+		onuFilter = paramONU
+	}
+	if paramPON := r.URL.Query().Get("pon"); paramPON != "" {
+		ponFilter = paramPON
+	}
+	if onuFilter != "" || ponFilter != "" {
+		data = data.FilterONUs(func(onu model.ONU) bool {
+			if onuFilter != "" && onu.Serial != onuFilter {
+				return false
+			}
+			if ponFilter != "" && (onu.OLTPort == nil || fmt.Sprintf("%.0f", *onu.OLTPort) != ponFilter) {
+				return false
+			}
+			return true
+		})
+		// a missing ONU fails the probe, so it can be alerted on
+		if data.Succeeded(api.SectionONUs) && len(*data.ONUs) == 0 {
+			requestLog.Error().Str("onu", onuFilter).Str("pon", ponFilter).Msg("no ONU found")
 			success = 0
 		}
+	}
 
-		probeDurationGauge := prometheus.NewGauge(prometheus.GaugeOpts{
-			Name: "probe_duration_seconds",
-			Help: "Returns how long the probe took to complete in seconds",
-		})
-		registry.MustRegister(probeDurationGauge)
-		duration := time.Since(start).Seconds()
-		probeDurationGauge.Set(duration)
+	// export the sections that were fetched successfully, even if others failed
+	addSectionMetrics(data, exporterRegistry)
+	err = addMetrics(data, data.Available(deviceOptions), deviceNaming(*device), exporterRegistry)
+	if err != nil {
+		requestLog.Err(err).Msg("error adding metrics")
+		success = 0
 	}
 
+	probeDurationGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_duration_seconds",
+		Help: "Returns how long the probe took to complete in seconds",
+	})
+	deviceRegistry.MustRegister(probeDurationGauge)
+	duration := time.Since(start).Seconds()
+	probeDurationGauge.Set(duration)
+
 	probeSuccessGauge := prometheus.NewGauge(prometheus.GaugeOpts{
 		Name: "probe_success",
 		Help: "Displays whether or not the probe was a success",
 	})
-	registry.MustRegister(probeSuccessGauge)
+	deviceRegistry.MustRegister(probeSuccessGauge)
 	probeSuccessGauge.Set(success)
 
 	if debug || trace {
 		mfs, _ := registry.Gather()
 		for _, mf := range mfs {
-			expfmt.MetricFamilyToText(w, mf)
+			expfmt.MetricFamilyToText(debugOut, mf)
 		}
 		return
 	}
 
-	h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
-	h.ServeHTTP(w, r)
+	serveMetrics(w, r, collector.WithUnits(registry), deviceNaming(*device), requestLog)
 }
 
-func getTimeout(config *config.Config, r *http.Request) float64 {
-	value := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")
-	if value != "" {
-		timeout, err := strconv.ParseFloat(value, 64)
-		if err != nil {
-			return timeout
-		}
+// serveMetrics writes the metrics of gatherer in the negotiated format,
+// OpenMetrics is encoded here, as promhttp does not write the units of the metrics.
+// The legacy names are only served in the text format, as OpenMetrics requires the _total suffix of their counters.
+func serveMetrics(w http.ResponseWriter, r *http.Request, gatherer prometheus.Gatherer, naming collector.Naming, log zerolog.Logger) {
+	format := expfmt.NegotiateIncludingOpenMetrics(r.Header)
+	if format.FormatType() != expfmt.TypeOpenMetrics || naming == collector.NamingLegacy {
+		h := promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{})
+		h.ServeHTTP(w, r)
+		return
 	}
-	return config.Timeout
-}
 
-type apiData struct {
-	statistics   *model.Statistics
-	interfaces   *[]model.InterfacesInterface
-	onus         *[]model.ONU
-	onusSettings *[]model.ONUSettings
-	macTable     *[]model.MACTable
-}
+	mfs, err := gatherer.Gather()
+	if err != nil {
+		log.Err(err).Msg("error gathering metrics")
+		http.Error(w, "error gathering metrics", http.StatusInternalServerError)
+		return
+	}
 
-func addMetrics(data apiData, deviceOptions config.Options, registry prometheus.Registerer) error {
-	if deviceOptions.ExportOLT {
-		err := collector.AddMetricsOlt(prometheus.WrapRegistererWithPrefix("olt_", registry), *data.statistics, *data.interfaces)
+	w.Header().Set("Content-Type", string(format))
+	enc := expfmt.NewEncoder(w, format, expfmt.WithUnit())
+	for _, mf := range mfs {
+		err := enc.Encode(mf)
 		if err != nil {
-			return err
+			log.Err(err).Msg("error encoding metrics")
+			return
 		}
 	}
-	if deviceOptions.ExportONUs {
-		err := collector.AddMetricsOnu(prometheus.WrapRegistererWithPrefix("onu_", registry), *data.onus, *data.onusSettings)
-		if err != nil {
-			return err
-		}
+	if closer, ok := enc.(expfmt.Closer); ok {
+		closer.Close()
 	}
-	if deviceOptions.ExportMACTable {
-		err := collector.AddMetricsOnuMACTable(prometheus.WrapRegistererWithPrefix("onu_", registry), *data.macTable)
-		if err != nil {
-			return err
-		}
+}
+
+// syncWriter serializes the writes to the response of a debug probe and drops them once the probe finished,
+// as shared API requests can still log with the logger of the probe that started them
+type syncWriter struct {
+	mutex  sync.Mutex
+	w      io.Writer
+	closed bool
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return len(p), nil
 	}
+	return s.w.Write(p)
+}
 
+func (s *syncWriter) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.closed = true
 	return nil
 }
 
-func getFromAPIWithRetry(ctx context.Context, log zerolog.Logger, target string, device config.Device, deviceOptions config.Options) (apiData, error) {
-	data, err := getFromAPI(ctx, log, target, device, deviceOptions)
-	if err != nil {
-		if errors.Is(err, context.Canceled) {
-			return data, err
-		}
-		// if there was an error somewhere, retry once
-		log.Err(err).Msg("error on first try")
-
-		// remove auth token, so login will be repeated
-		authCache.Remove(target)
-		data, err = getFromAPI(ctx, log, target, device, deviceOptions)
-		if err != nil {
-			return data, fmt.Errorf("error after retry: %w", err)
+// getTimeout returns the configured timeout, limited to the scrape timeout sent by Prometheus
+func getTimeout(defaultTimeout float64, r *http.Request) float64 {
+	value := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")
+	if value != "" {
+		timeout, err := strconv.ParseFloat(value, 64)
+		if err == nil && timeout > 0 && timeout < defaultTimeout {
+			return timeout
 		}
 	}
+	return defaultTimeout
+}
 
-	return data, nil
+func addDeviceInfoMetric(target string, device config.Device, registry prometheus.Registerer) {
+	infoGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "device_info",
+		Help: "Information about the probed device, with its labels from the configuration.",
+	}, []string{"name", "address"})
+	registry.MustRegister(infoGaugeVec)
+	infoGaugeVec.WithLabelValues(target, device.Address).Set(1)
 }
 
-func getFromAPI(ctx context.Context, log zerolog.Logger, target string, device config.Device, deviceOptions config.Options) (apiData, error) {
-	data := apiData{}
-	auth := authCache.Get(target)
-	// if there is no X-Auth-Token in the cache try to login
-	if auth == "" {
-		res, err := api.DoLogin(ctx, log, device)
+func addRequestMetrics(data api.Data, registry prometheus.Registerer) {
+	durationGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_api_request_duration_seconds",
+		Help: "Duration of the requests to the API of the probe by endpoint.",
+	}, []string{"endpoint"})
+	registry.MustRegister(durationGaugeVec)
+	failedGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_api_request_failed",
+		Help: "Displays whether the request to the API of the probe failed, by endpoint and error class",
+	}, []string{"endpoint", "class"})
+	registry.MustRegister(failedGaugeVec)
+
+	for endpoint, duration := range data.Durations {
+		durationGaugeVec.WithLabelValues(endpoint).Set(duration.Seconds())
+	}
+	for endpoint, err := range data.Errors {
+		failedGaugeVec.WithLabelValues(endpoint, api.ErrorClass(err)).Set(1)
+	}
+}
 
-		if err != nil {
-			return data, err
+func addSectionMetrics(data api.Data, registry prometheus.Registerer) {
+	sectionSuccessGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_section_success",
+		Help: "Displays whether or not the section of the probe was a success",
+	}, []string{"section"})
+	registry.MustRegister(sectionSuccessGaugeVec)
+
+	for section, err := range data.Sections {
+		var success float64
+		if err == nil {
+			success = 1
 		}
+		sectionSuccessGaugeVec.WithLabelValues(section).Set(success)
+	}
+}
 
-		auth = res.Header.Get("X-Auth-Token")
-		if auth == "" {
-			return data, errors.New("no X-Auth-Token after login")
-		}
-		authCache.Set(target, auth)
+func addSnapshotMetrics(snap snapshot, registry prometheus.Registerer) {
+	lastErrorGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "poll_last_error",
+		Help: "Whether the last background poll of the device failed.",
+	})
+	registry.MustRegister(lastErrorGauge)
+	if snap.err != nil {
+		lastErrorGauge.Set(1)
 	}
 
-	if deviceOptions.ExportOLT {
-		statistics, err := api.GetStatistics(ctx, log, device, auth)
-		if err != nil {
-			return data, err
-		}
-		data.statistics = statistics
+	if !snap.time.IsZero() {
+		ageGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "poll_snapshot_age_seconds",
+			Help: "Age of the latest successfully polled snapshot.",
+		})
+		registry.MustRegister(ageGauge)
+		ageGauge.Set(time.Since(snap.time).Seconds())
+	}
+}
 
-		interfaces, err := api.GetInterfaces(ctx, log, device, auth)
+// deviceNaming returns the names of the metrics of device
+func deviceNaming(device config.Device) collector.Naming {
+	if *device.LegacyMetricNames {
+		return collector.NamingLegacy
+	}
+	return collector.NamingV2
+}
+
+func addMetrics(data api.Data, deviceOptions config.Options, naming collector.Naming, registry prometheus.Registerer) error {
+	if deviceOptions.ExportOLT {
+		err := collector.AddMetricsOlt(prometheus.WrapRegistererWithPrefix("olt_", registry), naming, *data.Statistics, *data.Interfaces)
 		if err != nil {
-			return data, err
+			return err
 		}
-		data.interfaces = interfaces
 	}
 	if deviceOptions.ExportONUs {
-		onus, err := api.GetONUs(ctx, log, device, auth)
+		err := collector.AddMetricsOnu(prometheus.WrapRegistererWithPrefix("onu_", registry), naming, *data.ONUs, *data.ONUsSettings)
 		if err != nil {
-			return data, err
-		}
-		data.onus = onus
-
-		onusSettings, err := api.GetONUsSettings(ctx, log, device, auth)
-		if err != nil {
-			return data, err
+			return err
 		}
-		data.onusSettings = onusSettings
 	}
 	if deviceOptions.ExportMACTable {
-		macTable, err := api.GetMACTable(ctx, log, device, auth)
+		err := collector.AddMetricsOnuMACTable(prometheus.WrapRegistererWithPrefix("onu_", registry), *data.MACTable)
 		if err != nil {
-			return data, err
+			return err
 		}
-		data.macTable = macTable
 	}
 
-	return data, nil
+	return nil
 }
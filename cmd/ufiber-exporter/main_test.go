@@ -0,0 +1,604 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	"github.com/swoga/ufiber-exporter/config"
+	"github.com/swoga/ufiber-exporter/fakeolt"
+)
+
+func setupFakeOLT(t *testing.T, password string, deviceYAML ...string) *fakeolt.Server {
+	t.Helper()
+
+	olt, err := fakeolt.New("../../testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := olt.NewTLSServer()
+	t.Cleanup(ts.Close)
+
+	configFile := filepath.Join(t.TempDir(), "config.yml")
+	configYAML := fmt.Sprintf(`global:
+  username: ubnt
+  password: %s
+devices:
+  - name: olt
+    address: %s
+%s`, password, ts.Listener.Addr(), strings.Join(deviceYAML, ""))
+	err = os.WriteFile(configFile, []byte(configYAML), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sc = config.New(configFile)
+	err = sc.LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCache.Clear()
+	sessionCache.Clear()
+	polls.update(sc.Get())
+	t.Cleanup(polls.stop)
+
+	return olt
+}
+
+// waitPolled blocks until the first poll of target succeeded
+func waitPolled(t *testing.T, target string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, _, err := polls.get(target); err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no snapshot polled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func probe(t *testing.T, query string) string {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodGet, "/probe?"+query, nil)
+	w := httptest.NewRecorder()
+	handleRequest(w, r)
+
+	body, err := io.ReadAll(w.Result().Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func assertContains(t *testing.T, body string, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("missing %q in response:\n%s", line, body)
+		}
+	}
+}
+
+func TestProbe(t *testing.T) {
+	olt := setupFakeOLT(t, "ubnt")
+
+	body := probe(t, "target=olt&export_mac_table=1")
+	assertContains(t, body,
+		"probe_success 1",
+		`ufiber_exporter_olt_cpu_usage_ratio{cpu="cpu0"} 0.14`,
+		`ufiber_exporter_onu_connected{serial="UBNTxxxxxxx1"} 1`,
+		`ufiber_exporter_onu_fdb{mac="f0:9f:c2:00:00:03",serial="UBNTxxxxxxx2"} 1`,
+	)
+
+	probe(t, "target=olt")
+	if n := olt.Requests("user/login"); n != 1 {
+		t.Errorf("expected session to be reused, got %d logins", n)
+	}
+}
+
+func TestProbeTokenExpired(t *testing.T) {
+	olt := setupFakeOLT(t, "ubnt")
+
+	assertContains(t, probe(t, "target=olt"), "probe_success 1")
+	olt.ExpireTokens()
+	assertContains(t, probe(t, "target=olt"), "probe_success 1")
+
+	if n := olt.Requests("user/login"); n != 2 {
+		t.Errorf("expected a new login after token expiry, got %d logins", n)
+	}
+}
+
+func TestProbeServerError(t *testing.T) {
+	olt := setupFakeOLT(t, "ubnt")
+	olt.SetError("gpon/onus", http.StatusInternalServerError)
+
+	assertContains(t, probe(t, "target=olt"), "probe_success 0")
+
+	if n := olt.Requests("gpon/onus"); n != 1 {
+		t.Errorf("expected no retry after server error, got %d requests", n)
+	}
+}
+
+func TestProbeInvalidCredentials(t *testing.T) {
+	olt := setupFakeOLT(t, "wrong")
+
+	assertContains(t, probe(t, "target=olt"), "probe_success 0")
+
+	if n := olt.Requests("user/login"); n != 1 {
+		t.Errorf("expected no retry after failed login, got %d logins", n)
+	}
+}
+
+func TestProbePolled(t *testing.T) {
+	olt := setupFakeOLT(t, "ubnt", "    poll_interval: 60\n")
+
+	waitPolled(t, "olt")
+
+	assertContains(t, probe(t, "target=olt"),
+		"probe_success 1",
+		"ufiber_exporter_poll_last_error 0",
+		`ufiber_exporter_onu_connected{serial="UBNTxxxxxxx1"} 1`,
+	)
+
+	// a requested section that is not polled fails
+	body := probe(t, "target=olt&export_mac_table=1")
+	assertContains(t, body,
+		"probe_success 0",
+		`ufiber_exporter_probe_section_success{section="mac_table"} 0`,
+		`ufiber_exporter_onu_connected{serial="UBNTxxxxxxx1"} 1`,
+	)
+	if strings.Contains(body, "ufiber_exporter_onu_fdb") {
+		t.Error("mac table is not part of the snapshot")
+	}
+
+	// sections that are not requested are not served from the snapshot
+	body = probe(t, "target=olt&export_onus=0")
+	assertContains(t, body, "probe_success 1")
+	if strings.Contains(body, "ufiber_exporter_onu_connected") || strings.Contains(body, `section="onus"`) {
+		t.Error("onus were not requested")
+	}
+
+	if n := olt.Requests("gpon/onus"); n != 1 {
+		t.Errorf("expected probe to use the snapshot, got %d requests", n)
+	}
+
+	// a failed poll fails the probe, while the snapshot is still served
+	olt.SetError("gpon/onus", http.StatusInternalServerError)
+	device, _ := sc.Get().GetDevice("olt")
+	polls.poll(context.Background(), log.Logger, *device)
+	assertContains(t, probe(t, "target=olt"),
+		"probe_success 0",
+		"ufiber_exporter_poll_last_error 1",
+		`ufiber_exporter_probe_section_success{section="olt"} 1`,
+	)
+}
+
+func TestProbeConcurrent(t *testing.T) {
+	olt := setupFakeOLT(t, "ubnt", "    concurrency: 4\n")
+	for endpoint := range fakeolt.Fixtures {
+		olt.SetLatency(endpoint, 200*time.Millisecond)
+	}
+
+	start := time.Now()
+	body := probe(t, "target=olt")
+	assertContains(t, body, "probe_success 1")
+	if !strings.Contains(body, `ufiber_exporter_probe_api_request_duration_seconds{endpoint="gpon/onus"} `) {
+		t.Errorf("missing endpoint duration in response:\n%s", body)
+	}
+
+	// 4 endpoints with 200ms latency each
+	if duration := time.Since(start); duration > 600*time.Millisecond {
+		t.Errorf("expected endpoints to be requested in parallel, took %s", duration)
+	}
+}
+
+func TestProbeRequestErrors(t *testing.T) {
+	olt := setupFakeOLT(t, "ubnt")
+	olt.SetError("interfaces", http.StatusServiceUnavailable)
+
+	body := probe(t, "target=olt&export_onus=0")
+	assertContains(t, body,
+		"probe_success 0",
+		"# TYPE ufiber_exporter_probe_api_request_failed gauge",
+		`ufiber_exporter_probe_api_request_failed{class="server",endpoint="interfaces"} 1`,
+	)
+}
+
+func TestProbePartialSuccess(t *testing.T) {
+	olt := setupFakeOLT(t, "ubnt")
+	olt.SetError("tools/mac-table", http.StatusGatewayTimeout)
+
+	body := probe(t, "target=olt&export_mac_table=1")
+	assertContains(t, body,
+		"probe_success 0",
+		`ufiber_exporter_probe_section_success{section="olt"} 1`,
+		`ufiber_exporter_probe_section_success{section="onus"} 1`,
+		`ufiber_exporter_probe_section_success{section="mac_table"} 0`,
+		`ufiber_exporter_olt_cpu_usage_ratio{cpu="cpu0"} 0.14`,
+		`ufiber_exporter_onu_connected{serial="UBNTxxxxxxx1"} 1`,
+	)
+}
+
+func TestProbeTokenMaxAge(t *testing.T) {
+	olt := setupFakeOLT(t, "ubnt", "    token_max_age: 0.1\n")
+
+	assertContains(t, probe(t, "target=olt"), "probe_success 1")
+	time.Sleep(150 * time.Millisecond)
+	assertContains(t, probe(t, "target=olt"), "probe_success 1")
+
+	if n := olt.Requests("user/login"); n != 2 {
+		t.Errorf("expected a new login after max age, got %d logins", n)
+	}
+	if n := olt.Sessions(); n != 1 {
+		t.Errorf("expected the session that exceeded max age to be logged out, got %d sessions", n)
+	}
+}
+
+func TestTokenMaxAgeLateRejection(t *testing.T) {
+	olt := setupFakeOLT(t, "ubnt", "    token_max_age: 0.2\n")
+	assertContains(t, probe(t, "target=olt"), "probe_success 1")
+
+	// a slow request still uses the old token, while another probe logs in again after the max age
+	olt.Block("gpon/onus")
+	slow := make(chan string)
+	go func() {
+		slow <- probe(t, "target=olt&export_olt=0")
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for olt.Requests("gpon/onus") != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("slow request was not sent")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(250 * time.Millisecond)
+	assertContains(t, probe(t, "target=olt&export_onus=0"), "probe_success 1")
+
+	// the old token was logged out, its late rejection must not reset the new token
+	olt.Unblock("gpon/onus")
+	assertContains(t, <-slow, "probe_success 1")
+
+	if n := olt.Requests("user/login"); n != 2 {
+		t.Errorf("expected the new token to be kept, got %d logins", n)
+	}
+	if n := olt.Sessions(); n != 1 {
+		t.Errorf("expected 1 session, got %d", n)
+	}
+}
+
+func TestReloadLogout(t *testing.T) {
+	olt := setupFakeOLT(t, "ubnt")
+
+	assertContains(t, probe(t, "target=olt"), "probe_success 1")
+	if n := olt.Sessions(); n != 1 {
+		t.Fatalf("expected 1 session, got %d", n)
+	}
+
+	// an unchanged device keeps its session
+	reloadClients(log.Logger, sc.Get())
+	if clientCache.Get("olt") == nil {
+		t.Fatal("client of unchanged device was removed")
+	}
+
+	// a removed device logs out
+	reloadClients(log.Logger, &config.Config{})
+	deadline := time.Now().Add(5 * time.Second)
+	for olt.Sessions() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("session of removed device was not logged out")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestShutdownTokenFile(t *testing.T) {
+	olt := setupFakeOLT(t, "ubnt")
+	tokenFile := filepath.Join(t.TempDir(), "tokens.json")
+	conf := *sc.Get()
+	conf.TokenFile = tokenFile
+
+	assertContains(t, probe(t, "target=olt"), "probe_success 1")
+	shutdownClients(log.Logger, &conf)
+
+	// restart with the persisted session
+	clientCache.Clear()
+	sessionCache.Clear()
+	err := sessionCache.Load(tokenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContains(t, probe(t, "target=olt"), "probe_success 1")
+
+	if n := olt.Requests("user/login"); n != 1 {
+		t.Errorf("expected persisted session to be reused, got %d logins", n)
+	}
+}
+
+func TestRestoredSessionRemovedDevice(t *testing.T) {
+	olt := setupFakeOLT(t, "ubnt")
+	tokenFile := filepath.Join(t.TempDir(), "tokens.json")
+	conf := *sc.Get()
+	conf.TokenFile = tokenFile
+
+	assertContains(t, probe(t, "target=olt"), "probe_success 1")
+	shutdownClients(log.Logger, &conf)
+
+	// restart without the device
+	clientCache.Clear()
+	sessionCache.Clear()
+	err := sessionCache.Load(tokenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dropRestoredSessions(log.Logger, &config.Config{Global: conf.Global})
+
+	if len(sessionCache.Items()) != 0 {
+		t.Error("restored session of removed device was kept")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for olt.Sessions() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("restored session of removed device was not logged out")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// deduplicatedRequests returns the number of requests to endpoint that joined an in-flight request
+func deduplicatedRequests(t *testing.T, endpoint string) float64 {
+	t.Helper()
+	mfs, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != "ufiber_exporter_api_deduplicated_requests_total" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "endpoint" && label.GetValue() == endpoint {
+					return m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return 0
+}
+
+// waitJoined blocks until n requests to endpoint joined an in-flight request since start
+func waitJoined(t *testing.T, endpoint string, start float64, n float64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for deduplicatedRequests(t, endpoint) < start+n {
+		if time.Now().After(deadline) {
+			t.Fatalf("requests to %s did not join the in-flight request", endpoint)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestProbeDeduplicated(t *testing.T) {
+	olt := setupFakeOLT(t, "ubnt")
+	// hold the login and the ONUs until all probes joined the in-flight requests
+	olt.Block("user/login")
+	olt.Block("gpon/onus")
+	logins := deduplicatedRequests(t, "user/login")
+	onus := deduplicatedRequests(t, "gpon/onus")
+
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assertContains(t, probe(t, "target=olt"), "probe_success 1")
+		}()
+	}
+	waitJoined(t, "user/login", logins, 4)
+	olt.Unblock("user/login")
+	waitJoined(t, "gpon/onus", onus, 4)
+	olt.Unblock("gpon/onus")
+	wg.Wait()
+
+	if n := olt.Requests("user/login"); n != 1 {
+		t.Errorf("expected concurrent probes to share the login, got %d logins", n)
+	}
+	if n := olt.Requests("gpon/onus"); n != 1 {
+		t.Errorf("expected concurrent probes to share the request, got %d requests", n)
+	}
+}
+
+func TestProbeProfile(t *testing.T) {
+	olt := setupFakeOLT(t, "ubnt", `    profile: onu_only
+profiles:
+  onu_only:
+    options:
+      export_olt: false
+  full:
+    options:
+      export_olt: true
+      export_mac_table: true
+  onu2:
+    onu: UBNTxxxxxxx2
+`)
+
+	probe(t, "target=olt")
+	if n := olt.Requests("statistics"); n != 0 {
+		t.Errorf("expected the profile of the device to disable the olt section, got %d requests", n)
+	}
+	if n := olt.Requests("gpon/onus"); n != 1 {
+		t.Errorf("expected the global options to enable the onus section, got %d requests", n)
+	}
+
+	assertContains(t, probe(t, "target=olt&profile=full"),
+		"probe_success 1",
+		`ufiber_exporter_olt_cpu_usage_ratio{cpu="cpu0"} 0.14`,
+		`ufiber_exporter_onu_fdb{mac="f0:9f:c2:00:00:03",serial="UBNTxxxxxxx2"} 1`,
+	)
+
+	body := probe(t, "target=olt&profile=onu2")
+	assertContains(t, body, `ufiber_exporter_onu_connected{serial="UBNTxxxxxxx2"} 1`)
+	if strings.Contains(body, "UBNTxxxxxxx1") {
+		t.Errorf("expected the filter of the profile to limit the ONUs:\n%s", body)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/probe?target=olt&profile=unknown", nil)
+	w := httptest.NewRecorder()
+	handleRequest(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected bad request for unknown profile, got %d", w.Code)
+	}
+}
+
+func TestGetTimeout(t *testing.T) {
+	for header, expected := range map[string]float64{
+		"":        30,
+		"10":      10,
+		"60":      30,
+		"invalid": 30,
+		"0":       30,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/probe", nil)
+		if header != "" {
+			r.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", header)
+		}
+		if timeout := getTimeout(30, r); timeout != expected {
+			t.Errorf("header %q: expected timeout %v, got %v", header, expected, timeout)
+		}
+	}
+}
+
+func TestProbeLabels(t *testing.T) {
+	setupFakeOLT(t, "ubnt", `    labels:
+      site: zrh
+      rack: r1
+`)
+
+	body := probe(t, "target=olt&export_mac_table=1")
+	assertContains(t, body,
+		`probe_success{rack="r1",site="zrh"} 1`,
+		`ufiber_exporter_device_info{address="`+sc.Get().Devices[0].Address+`",name="olt",rack="r1",site="zrh"} 1`,
+		`ufiber_exporter_olt_cpu_usage_ratio{cpu="cpu0",rack="r1",site="zrh"} 0.14`,
+		`ufiber_exporter_onu_connected{rack="r1",serial="UBNTxxxxxxx1",site="zrh"} 1`,
+	)
+
+	// device labels must not collide with the labels of the metrics
+	labelName := regexp.MustCompile(`[{,]([a-zA-Z_][a-zA-Z0-9_]*)="`)
+	for _, match := range labelName.FindAllStringSubmatch(body, -1) {
+		if name := match[1]; !config.ReservedLabels[name] && name != "site" && name != "rack" {
+			t.Errorf("label %q is missing in config.ReservedLabels", name)
+		}
+	}
+}
+
+func TestProbeLegacyMetricNames(t *testing.T) {
+	setupFakeOLT(t, "ubnt", `    legacy_metric_names: true
+`)
+
+	body := probe(t, "target=olt")
+	assertContains(t, body,
+		"probe_success 1",
+		`ufiber_exporter_olt_cpu_usage{cpu="cpu0"} 14`,
+		"# TYPE ufiber_exporter_olt_uptime counter",
+		`ufiber_exporter_onu_distance{serial="UBNTxxxxxxx1"} 8778`,
+	)
+}
+
+func TestProbeOpenMetrics(t *testing.T) {
+	setupFakeOLT(t, "ubnt")
+
+	r := httptest.NewRequest(http.MethodGet, "/probe?target=olt", nil)
+	r.Header.Set("Accept", "application/openmetrics-text;version=1.0.0")
+	w := httptest.NewRecorder()
+	handleRequest(w, r)
+
+	if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "application/openmetrics-text") {
+		t.Errorf("expected OpenMetrics, got %s", contentType)
+	}
+	assertContains(t, w.Body.String(),
+		"# TYPE ufiber_exporter_olt_uptime_seconds gauge",
+		"# UNIT ufiber_exporter_olt_uptime_seconds seconds",
+		"# UNIT ufiber_exporter_onu_rx_bytes bytes",
+		`ufiber_exporter_onu_rx_bytes_total{serial="UBNTxxxxxxx1"} 6.8066467e+08`,
+		"# EOF",
+	)
+}
+
+var update = flag.Bool("update", false, "update the golden .prom files")
+
+// varyingLines are the lines of a probe that differ between runs, like durations and the address of the fake OLT
+var varyingLines = regexp.MustCompile(`(?m)^.*(duration_seconds|device_info).*\n`)
+
+func TestProbeOpenMetricsLegacy(t *testing.T) {
+	setupFakeOLT(t, "ubnt", "    legacy_metric_names: true\n")
+
+	r := httptest.NewRequest(http.MethodGet, "/probe?target=olt", nil)
+	r.Header.Set("Accept", "application/openmetrics-text;version=1.0.0")
+	w := httptest.NewRecorder()
+	handleRequest(w, r)
+
+	// OpenMetrics would turn the counters without _total into unknown
+	if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain") {
+		t.Errorf("expected the text format for legacy names, got %s", contentType)
+	}
+	got := varyingLines.ReplaceAll(w.Body.Bytes(), nil)
+
+	golden := filepath.Join("testdata", "legacy_openmetrics.prom")
+	if *update {
+		err := os.MkdirAll("testdata", 0o755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(golden, got, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output differs from %s, run go test ./cmd/ufiber-exporter -update to regenerate:\n%s", golden, got)
+	}
+}
+
+func TestProbeONUFilter(t *testing.T) {
+	setupFakeOLT(t, "ubnt")
+
+	body := probe(t, "target=olt&export_olt=0&export_mac_table=1&onu=UBNTxxxxxxx2")
+	assertContains(t, body,
+		"probe_success 1",
+		`ufiber_exporter_onu_connected{serial="UBNTxxxxxxx2"} 1`,
+		`ufiber_exporter_onu_fdb{mac="f0:9f:c2:00:00:03",serial="UBNTxxxxxxx2"} 1`,
+	)
+	if strings.Contains(body, "UBNTxxxxxxx1") {
+		t.Errorf("expected only the filtered ONU, got:\n%s", body)
+	}
+
+	body = probe(t, "target=olt&export_olt=0&pon=4")
+	assertContains(t, body,
+		`ufiber_exporter_onu_connected{serial="UBNTxxxxxxx1"} 1`,
+		`ufiber_exporter_onu_connected{serial="UBNTxxxxxxx2"} 1`,
+	)
+
+	assertContains(t, probe(t, "target=olt&export_olt=0&pon=1"), "probe_success 0")
+	assertContains(t, probe(t, "target=olt&export_olt=0&onu=UBNTmissing"), "probe_success 0")
+}
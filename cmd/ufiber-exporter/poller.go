@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/swoga/ufiber-exporter/api"
+	"github.com/swoga/ufiber-exporter/config"
+)
+
+var (
+	pollSnapshotAgeDesc = prometheus.NewDesc(
+		"ufiber_exporter_poll_snapshot_age_seconds",
+		"Age of the latest successfully polled snapshot.",
+		[]string{"device"}, nil,
+	)
+	pollLastErrorDesc = prometheus.NewDesc(
+		"ufiber_exporter_poll_last_error",
+		"Whether the last background poll of the device failed.",
+		[]string{"device"}, nil,
+	)
+)
+
+// snapshot is the result of the background polling of one device
+type snapshot struct {
+	// data of the latest poll where at least one section succeeded
+	data api.Data
+	time time.Time
+	// err of the last poll, nil if it was successful
+	err error
+}
+
+// dataFor returns the data of the snapshot limited to the sections requested by options.
+// A requested section that is not part of the snapshot fails, the returned error also contains the error of the last poll,
+// so a probe of stale data fails.
+func (s snapshot) dataFor(options config.Options) (api.Data, error) {
+	data := s.data
+	data.Sections = map[string]error{}
+
+	var errs []error
+	if s.err != nil {
+		errs = append(errs, fmt.Errorf("last poll: %w", s.err))
+	}
+	requested := []struct {
+		section string
+		export  bool
+	}{
+		{api.SectionOLT, options.ExportOLT},
+		{api.SectionONUs, options.ExportONUs},
+		{api.SectionMACTable, options.ExportMACTable},
+	}
+	for _, r := range requested {
+		if !r.export {
+			continue
+		}
+		err, ok := s.data.Sections[r.section]
+		if !ok {
+			err = errors.New("section is not part of the polled snapshot")
+		}
+		data.Sections[r.section] = err
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", r.section, err))
+		}
+	}
+	return data, errors.Join(errs...)
+}
+
+// poll is the running background polling of one device
+type poll struct {
+	device config.Device
+	cancel context.CancelFunc
+}
+
+// poller fetches the data of all devices with a poll_interval in the background
+type poller struct {
+	mutex     sync.RWMutex
+	snapshots map[string]*snapshot
+	polls     map[string]poll
+}
+
+func newPoller() *poller {
+	return &poller{
+		snapshots: map[string]*snapshot{},
+		polls:     map[string]poll{},
+	}
+}
+
+// update starts the polls of the devices of conf, stops the ones of removed devices and restarts the ones of changed devices.
+// Unchanged devices keep polling on their interval, so a reload does not fetch all devices at once.
+func (p *poller) update(conf *config.Config) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	snapshots := map[string]*snapshot{}
+	polls := map[string]poll{}
+	for _, device := range conf.Devices {
+		if *device.PollInterval <= 0 {
+			continue
+		}
+		// keep the data of devices that are still configured
+		s, ok := p.snapshots[device.Name]
+		if !ok {
+			s = &snapshot{}
+		}
+		snapshots[device.Name] = s
+
+		running, ok := p.polls[device.Name]
+		if ok && reflect.DeepEqual(running.device, *device) {
+			polls[device.Name] = running
+			delete(p.polls, device.Name)
+			continue
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		polls[device.Name] = poll{device: *device, cancel: cancel}
+		go p.run(ctx, *device)
+	}
+	// stop the polls of removed and changed devices
+	for _, running := range p.polls {
+		running.cancel()
+	}
+	p.snapshots = snapshots
+	p.polls = polls
+}
+
+// stop stops all running polls
+func (p *poller) stop() {
+	p.update(&config.Config{})
+}
+
+// get returns the snapshot of a polled device, an error is returned if there was no successful poll yet
+func (p *poller) get(name string) (snapshot, bool, error) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	s, ok := p.snapshots[name]
+	if !ok {
+		return snapshot{}, false, nil
+	}
+	if s.time.IsZero() {
+		if s.err != nil {
+			return *s, true, s.err
+		}
+		return *s, true, errors.New("no snapshot polled yet")
+	}
+	return *s, true, nil
+}
+
+func (p *poller) run(ctx context.Context, device config.Device) {
+	log := log.With().Str("target", device.Name).Logger()
+	log.Debug().Float64("interval", *device.PollInterval).Msg("start polling")
+
+	ticker := time.NewTicker(time.Duration(*device.PollInterval * float64(time.Second)))
+	defer ticker.Stop()
+
+	for {
+		p.poll(ctx, log, device)
+		select {
+		case <-ctx.Done():
+			log.Debug().Msg("stop polling")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *poller) poll(ctx context.Context, log zerolog.Logger, device config.Device) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(*device.Timeout*float64(time.Second)))
+	defer cancel()
+
+	options := *device.Options
+	var data api.Data
+	client, err := getClient(device.Name, device)
+	if err == nil {
+		data, err = client.Fetch(ctx, log, options)
+	}
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return
+	}
+	if err != nil {
+		log.Err(err).Msg("error polling data from API")
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	s, ok := p.snapshots[device.Name]
+	if !ok {
+		return
+	}
+	s.err = err
+	if data.Available(options) != (config.Options{}) {
+		s.data = data
+		s.time = time.Now()
+	}
+}
+
+// Gather returns the metrics of the latest snapshots of all polled devices, with the name of the device as label
+// together with its labels, so the metrics path serves the polled data as well
+func (p *poller) Gather() ([]*dto.MetricFamily, error) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	// each device has its own registry, as the labels of the devices can differ
+	var gatherers prometheus.Gatherers
+	for name, s := range p.snapshots {
+		running, ok := p.polls[name]
+		if !ok || s.time.IsZero() {
+			continue
+		}
+		device := running.device
+
+		labels := prometheus.Labels{"device": name}
+		for label, value := range device.Labels {
+			labels[label] = value
+		}
+		registry := prometheus.NewRegistry()
+		exporterRegistry := prometheus.WrapRegistererWithPrefix("ufiber_exporter_", prometheus.WrapRegistererWith(labels, registry))
+		err := addMetrics(s.data, s.data.Available(*device.Options), deviceNaming(device), exporterRegistry)
+		if err != nil {
+			return nil, err
+		}
+		gatherers = append(gatherers, registry)
+	}
+	return gatherers.Gather()
+}
+
+func (p *poller) Describe(ch chan<- *prometheus.Desc) {
+	ch <- pollSnapshotAgeDesc
+	ch <- pollLastErrorDesc
+}
+
+func (p *poller) Collect(ch chan<- prometheus.Metric) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	for name, s := range p.snapshots {
+		var lastError float64
+		if s.err != nil {
+			lastError = 1
+		}
+		ch <- prometheus.MustNewConstMetric(pollLastErrorDesc, prometheus.GaugeValue, lastError, name)
+		if !s.time.IsZero() {
+			ch <- prometheus.MustNewConstMetric(pollSnapshotAgeDesc, prometheus.GaugeValue, time.Since(s.time).Seconds(), name)
+		}
+	}
+}
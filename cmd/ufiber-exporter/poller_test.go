@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+	"github.com/swoga/ufiber-exporter/config"
+)
+
+func TestPollerMetrics(t *testing.T) {
+	setupFakeOLT(t, "ubnt", `    poll_interval: 60
+    labels:
+      site: zrh
+`)
+	waitPolled(t, "olt")
+
+	mfs, err := polls.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body bytes.Buffer
+	for _, mf := range mfs {
+		expfmt.MetricFamilyToText(&body, mf)
+	}
+	assertContains(t, body.String(),
+		`ufiber_exporter_olt_cpu_usage_ratio{cpu="cpu0",device="olt",site="zrh"} 0.14`,
+		`ufiber_exporter_onu_connected{device="olt",serial="UBNTxxxxxxx1",site="zrh"} 1`,
+	)
+}
+
+func TestPollerReload(t *testing.T) {
+	olt := setupFakeOLT(t, "ubnt", "    poll_interval: 60\n")
+	waitPolled(t, "olt")
+
+	// an unchanged device keeps polling on its interval
+	polls.update(sc.Get())
+	time.Sleep(50 * time.Millisecond)
+	if n := olt.Requests("statistics"); n != 1 {
+		t.Errorf("expected the poll of the unchanged device to keep running, got %d requests", n)
+	}
+
+	// a changed device is polled again
+	conf := *sc.Get()
+	device := *conf.Devices[0]
+	interval := 30.0
+	device.PollInterval = &interval
+	conf.Devices = []*config.Device{&device}
+	polls.update(&conf)
+	deadline := time.Now().Add(5 * time.Second)
+	for olt.Requests("statistics") != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("changed device was not polled again")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+	"github.com/swoga/ufiber-exporter/config"
+)
+
+// targetGroup is a group of targets in the format of Prometheus http_sd_config and file_sd_config
+type targetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+// sdTargets returns a target group for each configured device and each of its discovered ONUs in onus by device name,
+// to be scraped over the exporter at address
+func sdTargets(conf *config.Config, address string, onus map[string][]string) []targetGroup {
+	groups := []targetGroup{}
+	for _, device := range conf.Devices {
+		labels := sdLabels(conf, device)
+		groups = append(groups, targetGroup{
+			Targets: []string{address},
+			Labels:  labels,
+		})
+
+		for _, serial := range onus[device.Name] {
+			labels := sdLabels(conf, device)
+			labels["__param_onu"] = serial
+			labels["__param_export_olt"] = "0"
+			labels["instance"] = device.Name + "/" + serial
+			groups = append(groups, targetGroup{
+				Targets: []string{address},
+				Labels:  labels,
+			})
+		}
+	}
+	return groups
+}
+
+func sdLabels(conf *config.Config, device *config.Device) map[string]string {
+	labels := map[string]string{}
+	for name, value := range device.Labels {
+		labels[name] = value
+	}
+	labels["__metrics_path__"] = conf.ProbePath
+	labels["__param_target"] = device.Name
+	if device.Profile != "" {
+		labels["__param_profile"] = device.Profile
+	}
+	// otherwise all devices would have the address of the exporter as instance
+	labels["instance"] = device.Name
+	return labels
+}
+
+// discoverONUs returns the serials of the ONUs of all devices with discover_onus by device name.
+// They are taken from the polled snapshots only, which the probes of the ONU targets filter,
+// so neither the discovery nor the probes request the ONUs from the device.
+func discoverONUs(conf *config.Config) map[string][]string {
+	onus := map[string][]string{}
+	for _, device := range conf.Devices {
+		if !*device.DiscoverONUs {
+			continue
+		}
+		log := log.With().Str("target", device.Name).Logger()
+
+		snap, polled, err := polls.get(device.Name)
+		if err == nil && !polled {
+			err = errors.New("device is not polled")
+		}
+		if err == nil && snap.data.ONUs == nil {
+			err = errors.New("ONUs are not exported by the polling")
+		}
+		if err != nil {
+			log.Err(err).Msg("error discovering ONUs")
+			continue
+		}
+
+		serials := []string{}
+		for _, onu := range *snap.data.ONUs {
+			serials = append(serials, onu.Serial)
+		}
+		onus[device.Name] = serials
+	}
+	return onus
+}
+
+// handleSD serves the configured devices and their discovered ONUs for http_sd_config,
+// the exporter is addressed as in the request
+func handleSD(w http.ResponseWriter, r *http.Request) {
+	conf := sc.Get()
+	onus := discoverONUs(conf)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sdTargets(conf, r.Host, onus))
+}
+
+// exporterAddress returns the address to reach the exporter on listen, if listen has no host localhost is used
+func exporterAddress(listen string) string {
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return listen
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// writeFile replaces file atomically, so Prometheus never reads a partially written file
+func writeFile(file string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(tmp.Name(), 0o644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestSD(t *testing.T) {
+	setupFakeOLT(t, "ubnt", `    profile: light
+    labels:
+      site: zrh
+profiles:
+  light:
+    options:
+      export_onus: false
+`)
+
+	r := httptest.NewRequest(http.MethodGet, "http://exporter:9777/sd", nil)
+	w := httptest.NewRecorder()
+	handleSD(w, r)
+
+	var groups []targetGroup
+	err := json.NewDecoder(w.Result().Body).Decode(&groups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []targetGroup{{
+		Targets: []string{"exporter:9777"},
+		Labels: map[string]string{
+			"__metrics_path__": "/probe",
+			"__param_target":   "olt",
+			"__param_profile":  "light",
+			"instance":         "olt",
+			"site":             "zrh",
+		},
+	}}
+	if !reflect.DeepEqual(groups, expected) {
+		t.Errorf("expected %+v, got %+v", expected, groups)
+	}
+}
+
+func TestExporterAddress(t *testing.T) {
+	for listen, expected := range map[string]string{
+		":9777":            "localhost:9777",
+		"0.0.0.0:9777":     "localhost:9777",
+		"10.0.0.1:9777":    "10.0.0.1:9777",
+		"[2001:db8::1]:80": "[2001:db8::1]:80",
+	} {
+		if address := exporterAddress(listen); address != expected {
+			t.Errorf("%s: expected %s, got %s", listen, expected, address)
+		}
+	}
+}
+
+func TestSDDiscoverONUs(t *testing.T) {
+	olt := setupFakeOLT(t, "ubnt", "    discover_onus: true\n    poll_interval: 60\n")
+	waitPolled(t, "olt")
+
+	r := httptest.NewRequest(http.MethodGet, "http://exporter:9777/sd", nil)
+	w := httptest.NewRecorder()
+	handleSD(w, r)
+
+	var groups []targetGroup
+	err := json.NewDecoder(w.Result().Body).Decode(&groups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 3 {
+		t.Fatalf("expected the device and its 2 ONUs, got %+v", groups)
+	}
+	expected := map[string]string{
+		"__metrics_path__":   "/probe",
+		"__param_target":     "olt",
+		"__param_onu":        "UBNTxxxxxxx2",
+		"__param_export_olt": "0",
+		"instance":           "olt/UBNTxxxxxxx2",
+	}
+	if !reflect.DeepEqual(groups[2].Labels, expected) {
+		t.Errorf("expected %v, got %v", expected, groups[2].Labels)
+	}
+
+	// the ONU target is served from the snapshot
+	assertContains(t, probe(t, "target=olt&onu=UBNTxxxxxxx2&export_olt=0"), "probe_success 1")
+	if n := olt.Requests("gpon/onus"); n != 1 {
+		t.Errorf("expected ONU discovery and probe to use the snapshot, got %d requests", n)
+	}
+}
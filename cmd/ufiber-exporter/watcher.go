@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// fileState is what is compared to detect a change of a file
+type fileState struct {
+	exists  bool
+	modTime time.Time
+	size    int64
+}
+
+func statFile(file string) fileState {
+	info, err := os.Stat(file)
+	if err != nil {
+		return fileState{}
+	}
+	return fileState{
+		exists:  true,
+		modTime: info.ModTime(),
+		size:    info.Size(),
+	}
+}
+
+// watcher polls files for changes, as the config is often mounted from a ConfigMap or a network share,
+// where polling is the reliable way to notice changes
+type watcher struct {
+	interval time.Duration
+	// debounce is the time without further changes, before a change is reported
+	debounce time.Duration
+	// files returns the files to watch, they are requested again on every check
+	files  func() []string
+	states map[string]fileState
+}
+
+func newWatcher(interval time.Duration, debounce time.Duration, files func() []string) *watcher {
+	w := &watcher{
+		interval: interval,
+		debounce: debounce,
+		files:    files,
+		states:   map[string]fileState{},
+	}
+	w.check()
+	return w
+}
+
+// check returns whether one of the files changed since the last check, files seen for the first time are no change
+func (w *watcher) check() bool {
+	changed := false
+	states := map[string]fileState{}
+	for _, file := range w.files() {
+		state := statFile(file)
+		if previous, ok := w.states[file]; ok && previous != state {
+			log.Debug().Str("file", file).Msg("file changed")
+			changed = true
+		}
+		states[file] = state
+	}
+	w.states = states
+	return changed
+}
+
+// run calls changed after files changed and stayed unchanged for the debounce time, until ctx is done
+func (w *watcher) run(ctx context.Context, changed func()) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	var lastChange time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if w.check() {
+			lastChange = time.Now()
+			continue
+		}
+		if !lastChange.IsZero() && time.Since(lastChange) >= w.debounce {
+			lastChange = time.Time{}
+			changed()
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatcher(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yml")
+	os.WriteFile(file, []byte("a"), 0o600)
+
+	w := newWatcher(10*time.Millisecond, 50*time.Millisecond, func() []string {
+		return []string{file}
+	})
+	changes := make(chan struct{}, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.run(ctx, func() {
+		changes <- struct{}{}
+	})
+
+	select {
+	case <-changes:
+		t.Fatal("change reported without a change")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// several writes within the debounce time are reported once
+	for _, content := range []string{"ab", "abc", "abcd"} {
+		os.WriteFile(file, []byte(content), 0o600)
+		time.Sleep(20 * time.Millisecond)
+	}
+	select {
+	case <-changes:
+	case <-time.After(time.Second):
+		t.Fatal("change not reported")
+	}
+	select {
+	case <-changes:
+		t.Fatal("change reported twice")
+	case <-time.After(150 * time.Millisecond):
+	}
+
+	os.Remove(file)
+	select {
+	case <-changes:
+	case <-time.After(time.Second):
+		t.Fatal("removal not reported")
+	}
+}
@@ -0,0 +1,145 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/swoga/ufiber-exporter/model"
+)
+
+var update = flag.Bool("update", false, "update the golden .prom files")
+
+// readFixture decodes testdata/<name>/<file> into data, returns false if the file does not exist
+func readFixture(t *testing.T, name string, file string, data interface{}) bool {
+	t.Helper()
+
+	buf, err := os.ReadFile(filepath.Join("testdata", name, file))
+	if errors.Is(err, os.ErrNotExist) {
+		return false
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = json.Unmarshal(buf, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return true
+}
+
+func TestGolden(t *testing.T) {
+	dirs, err := os.ReadDir("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	namings := map[string]Naming{
+		"":        NamingV2,
+		"_legacy": NamingLegacy,
+	}
+
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		for suffix, naming := range namings {
+			name := dir.Name()
+			t.Run(name+suffix, func(t *testing.T) {
+				testGolden(t, name, naming, filepath.Join("testdata", name+suffix+".prom"))
+			})
+		}
+	}
+}
+
+func testGolden(t *testing.T, name string, naming Naming, golden string) {
+	registry := prometheus.NewRegistry()
+	exporterRegistry := prometheus.WrapRegistererWithPrefix("ufiber_exporter_", registry)
+
+	var statistics []model.Statistics
+	var interfaces []model.InterfacesInterface
+	if readFixture(t, name, "statistics.json", &statistics) {
+		readFixture(t, name, "interfaces.json", &interfaces)
+		err := AddMetricsOlt(prometheus.WrapRegistererWithPrefix("olt_", exporterRegistry), naming, statistics[0], interfaces)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var onus []model.ONU
+	var onusSettings []model.ONUSettings
+	if readFixture(t, name, "onus.json", &onus) {
+		readFixture(t, name, "onussettings.json", &onusSettings)
+		err := AddMetricsOnu(prometheus.WrapRegistererWithPrefix("onu_", exporterRegistry), naming, onus, onusSettings)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var macTable []model.MACTable
+	if readFixture(t, name, "mactable.json", &macTable) {
+		err := AddMetricsOnuMACTable(prometheus.WrapRegistererWithPrefix("onu_", exporterRegistry), macTable)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got bytes.Buffer
+	for _, mf := range mfs {
+		_, err := expfmt.MetricFamilyToText(&got, mf)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if *update {
+		err := os.WriteFile(golden, got.Bytes(), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), want) {
+		t.Errorf("output differs from %s, run go test ./collector -update to regenerate:\n%s", golden, got.String())
+	}
+}
+
+func TestWithUnits(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		prometheus.NewGauge(prometheus.GaugeOpts{Name: "uptime_seconds"}),
+		prometheus.NewCounter(prometheus.CounterOpts{Name: "rx_bytes_total"}),
+		prometheus.NewGauge(prometheus.GaugeOpts{Name: "rx_rate_bits_per_second"}),
+		prometheus.NewGauge(prometheus.GaugeOpts{Name: "info"}),
+	)
+
+	mfs, err := WithUnits(registry).Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"info":                    "",
+		"rx_bytes_total":          "bytes",
+		"rx_rate_bits_per_second": "bits_per_second",
+		"uptime_seconds":          "seconds",
+	}
+	for _, mf := range mfs {
+		if mf.GetUnit() != want[mf.GetName()] {
+			t.Errorf("unit of %s: got %q, want %q", mf.GetName(), mf.GetUnit(), want[mf.GetName()])
+		}
+	}
+}
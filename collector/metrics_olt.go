@@ -7,35 +7,43 @@ import (
 	"github.com/swoga/ufiber-exporter/model"
 )
 
-func AddMetricsOlt(registry prometheus.Registerer, statistics model.Statistics, interfacesInterfaces []model.InterfacesInterface) error {
-	err := addMetricsOltDevice(registry, statistics.Device)
+func AddMetricsOlt(registry prometheus.Registerer, naming Naming, statistics model.Statistics, interfacesInterfaces []model.InterfacesInterface) error {
+	err := addMetricsOltDevice(registry, naming, statistics.Device)
 	if err != nil {
 		return err
 	}
-	err = addMetricsOltInterfaces(prometheus.WrapRegistererWithPrefix("interface_", registry), statistics.Interfaces, interfacesInterfaces)
+	err = addMetricsOltInterfaces(prometheus.WrapRegistererWithPrefix("interface_", registry), naming, statistics.Interfaces, interfacesInterfaces)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func addMetricsOltDevice(registry prometheus.Registerer, device model.Device) error {
+func addMetricsOltDevice(registry prometheus.Registerer, naming Naming, device model.Device) error {
 	// CPU
 	cpuGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "cpu_usage",
+		Name: naming.name("cpu_usage_ratio", "cpu_usage"),
+		Help: "CPU usage of the OLT, cpu is the aggregate of all CPUs.",
 	}, []string{"cpu"})
 	registry.MustRegister(cpuGaugeVec)
+	cpuTemperatureGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: naming.name("cpu_temperature_celsius", "cpu_temperature"),
+		Help: "Temperature of the CPU of the OLT.",
+	}, []string{"cpu"})
+	registry.MustRegister(cpuTemperatureGaugeVec)
 
 	for _, cpu := range device.CPU {
-		if cpu.Identifier == "cpu" {
-			continue
+		cpuGaugeVec.WithLabelValues(cpu.Identifier).Set(naming.percent(float64(cpu.Usage)))
+		// the aggregate of all CPUs has no temperature
+		if cpu.Identifier != "cpu" {
+			cpuTemperatureGaugeVec.WithLabelValues(cpu.Identifier).Set(cpu.Temperature)
 		}
-		cpuGaugeVec.WithLabelValues(cpu.Identifier).Set(float64(cpu.Usage))
 	}
 
 	// FANs
 	fanSpeedGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "fan_speed",
+		Name: naming.name("fan_speed_rpm", "fan_speed"),
+		Help: "Speed of the fan of the OLT.",
 	}, []string{"fan"})
 	registry.MustRegister(fanSpeedGaugeVec)
 
@@ -46,16 +54,24 @@ func addMetricsOltDevice(registry prometheus.Registerer, device model.Device) er
 	// PSUs
 	psuConnectedGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "psu_connected",
+		Help: "Whether the PSU of the OLT is connected.",
 	}, []string{"psu"})
 	registry.MustRegister(psuConnectedGaugeVec)
-	psuVoltageGauge := prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "psu_voltage",
-	})
-	registry.MustRegister(psuVoltageGauge)
-	psuPowerGauge := prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "psu_power",
-	})
-	registry.MustRegister(psuPowerGauge)
+	psuVoltageGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: naming.name("psu_voltage_volts", "psu_voltage"),
+		Help: "Voltage of the PSU of the OLT.",
+	}, []string{"psu", "type"})
+	registry.MustRegister(psuVoltageGaugeVec)
+	psuCurrentGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: naming.name("psu_current_amperes", "psu_current"),
+		Help: "Current of the PSU of the OLT.",
+	}, []string{"psu", "type"})
+	registry.MustRegister(psuCurrentGaugeVec)
+	psuPowerGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: naming.name("psu_power_watts", "psu_power"),
+		Help: "Power of the PSU of the OLT.",
+	}, []string{"psu", "type"})
+	registry.MustRegister(psuPowerGaugeVec)
 
 	for i, psu := range device.Power {
 		var connected float64
@@ -64,31 +80,43 @@ func addMetricsOltDevice(registry prometheus.Registerer, device model.Device) er
 		}
 		psuConnectedGaugeVec.WithLabelValues(strconv.Itoa(i)).Set(connected)
 
-		// voltage and power is only reported by one PSU
+		// a disconnected PSU reports no values
 		if psu.Voltage != nil {
-			psuVoltageGauge.Set(*psu.Voltage)
+			psuVoltageGaugeVec.WithLabelValues(strconv.Itoa(i), psu.PsuType).Set(*psu.Voltage)
+		}
+		if psu.Current != nil {
+			psuCurrentGaugeVec.WithLabelValues(strconv.Itoa(i), psu.PsuType).Set(*psu.Current)
 		}
 		if psu.Power != nil {
-			psuPowerGauge.Set(*psu.Power)
+			psuPowerGaugeVec.WithLabelValues(strconv.Itoa(i), psu.PsuType).Set(*psu.Power)
 		}
 	}
 
 	// RAM
 	ramTotalGauge := prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "ram_total",
+		Name: naming.name("ram_total_bytes", "ram_total"),
+		Help: "Total RAM of the OLT.",
 	})
 	registry.MustRegister(ramTotalGauge)
 	ramFreeGauge := prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "ram_free",
+		Name: naming.name("ram_free_bytes", "ram_free"),
+		Help: "Free RAM of the OLT.",
 	})
 	registry.MustRegister(ramFreeGauge)
+	ramUsageGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: naming.name("ram_usage_ratio", "ram_usage"),
+		Help: "RAM usage of the OLT.",
+	})
+	registry.MustRegister(ramUsageGauge)
 
 	ramTotalGauge.Set(device.RAM.Total)
 	ramFreeGauge.Set(device.RAM.Free)
+	ramUsageGauge.Set(naming.percent(device.RAM.Usage))
 
 	// Temperatures
 	temperatureGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "temperature",
+		Name: naming.name("temperature_celsius", "temperature"),
+		Help: "Temperature of the sensor of the OLT.",
 	}, []string{"sensor"})
 	registry.MustRegister(temperatureGaugeVec)
 
@@ -97,44 +125,97 @@ func addMetricsOltDevice(registry prometheus.Registerer, device model.Device) er
 	}
 
 	// Uptime
-	uptimeCounter := prometheus.NewCounter(prometheus.CounterOpts{
-		Name: "uptime",
-	})
-	registry.MustRegister(uptimeCounter)
+	uptime := newValueVec(registry, naming, prometheus.GaugeOpts{
+		Name: "uptime_seconds",
+		Help: "Uptime of the OLT.",
+	}, "uptime", nil)
 
-	uptimeCounter.Add(device.Uptime)
+	uptime.set(device.Uptime)
 	return nil
 }
 
-func addMetricsOltInterfaces(registry prometheus.Registerer, statisticsInterfaces []model.StatisticsInterface, interfacesInterfaces []model.InterfacesInterface) error {
+func addMetricsOltInterfaces(registry prometheus.Registerer, naming Naming, statisticsInterfaces []model.StatisticsInterface, interfacesInterfaces []model.InterfacesInterface) error {
 	interfaceRxBytesCounterVec := prometheus.NewCounterVec(prometheus.CounterOpts{
-		Name: "rx_bytes",
+		Name: naming.name("rx_bytes_total", "rx_bytes"),
+		Help: "Received bytes of the interface.",
 	}, []string{"name"})
 	registry.MustRegister(interfaceRxBytesCounterVec)
 	interfaceRxPacketsCounterVec := prometheus.NewCounterVec(prometheus.CounterOpts{
-		Name: "rx_packets",
+		Name: naming.name("rx_packets_total", "rx_packets"),
+		Help: "Received packets of the interface.",
 	}, []string{"name"})
 	registry.MustRegister(interfaceRxPacketsCounterVec)
 	interfaceTxBytesCounterVec := prometheus.NewCounterVec(prometheus.CounterOpts{
-		Name: "tx_bytes",
+		Name: naming.name("tx_bytes_total", "tx_bytes"),
+		Help: "Transmitted bytes of the interface.",
 	}, []string{"name"})
 	registry.MustRegister(interfaceTxBytesCounterVec)
 	interfaceTxPacketsCounterVec := prometheus.NewCounterVec(prometheus.CounterOpts{
-		Name: "tx_packets",
+		Name: naming.name("tx_packets_total", "tx_packets"),
+		Help: "Transmitted packets of the interface.",
 	}, []string{"name"})
 	registry.MustRegister(interfaceTxPacketsCounterVec)
+	interfaceRxBroadcastCounterVec := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: naming.name("rx_broadcast_packets_total", "rx_broadcast"),
+		Help: "Received broadcast packets of the interface.",
+	}, []string{"name"})
+	registry.MustRegister(interfaceRxBroadcastCounterVec)
+	interfaceRxMulticastCounterVec := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: naming.name("rx_multicast_packets_total", "rx_multicast"),
+		Help: "Received multicast packets of the interface.",
+	}, []string{"name"})
+	registry.MustRegister(interfaceRxMulticastCounterVec)
+	interfaceTxBroadcastCounterVec := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: naming.name("tx_broadcast_packets_total", "tx_broadcast"),
+		Help: "Transmitted broadcast packets of the interface.",
+	}, []string{"name"})
+	registry.MustRegister(interfaceTxBroadcastCounterVec)
+	interfaceTxMulticastCounterVec := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: naming.name("tx_multicast_packets_total", "tx_multicast"),
+		Help: "Transmitted multicast packets of the interface.",
+	}, []string{"name"})
+	registry.MustRegister(interfaceTxMulticastCounterVec)
+
+	interfaceRxRateGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: naming.name("rx_rate_bits_per_second", "rx_rate"),
+		Help: "Receive rate of the interface.",
+	}, []string{"name"})
+	registry.MustRegister(interfaceRxRateGaugeVec)
+	interfaceTxRateGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: naming.name("tx_rate_bits_per_second", "tx_rate"),
+		Help: "Transmit rate of the interface.",
+	}, []string{"name"})
+	registry.MustRegister(interfaceTxRateGaugeVec)
 
 	interfaceRxPowerGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "rx_power",
+		Name: naming.name("sfp_rx_power_dbm", "rx_power"),
+		Help: "Receive power of the SFP of the interface.",
 	}, []string{"name"})
 	registry.MustRegister(interfaceRxPowerGaugeVec)
 	interfaceSfpTemperatureGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "sfp_temperature",
+		Name: naming.name("sfp_temperature_celsius", "sfp_temperature"),
+		Help: "Temperature of the SFP of the interface.",
 	}, []string{"name"})
 	registry.MustRegister(interfaceSfpTemperatureGaugeVec)
+	interfaceTxPowerGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: naming.name("sfp_tx_power_dbm", "tx_power"),
+		Help: "Transmit power of the SFP of the interface.",
+	}, []string{"name"})
+	registry.MustRegister(interfaceTxPowerGaugeVec)
+	interfaceSfpCurrentGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: naming.name("sfp_bias_current_amperes", "sfp_current"),
+		Help: "Laser bias current of the SFP of the interface.",
+	}, []string{"name"})
+	registry.MustRegister(interfaceSfpCurrentGaugeVec)
+	interfaceSfpVoltageGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: naming.name("sfp_voltage_volts", "sfp_voltage"),
+		Help: "Supply voltage of the SFP of the interface.",
+	}, []string{"name"})
+	registry.MustRegister(interfaceSfpVoltageGaugeVec)
 
 	interfaceNameGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "name",
+		Name: naming.name("name_info", "name"),
+		Help: "Name given to the interface.",
 	}, []string{"name", "given_name"})
 	registry.MustRegister(interfaceNameGaugeVec)
 
@@ -151,6 +232,24 @@ func addMetricsOltInterfaces(registry prometheus.Registerer, statisticsInterface
 		if interf.Statistics.TxPackets != nil {
 			interfaceTxPacketsCounterVec.WithLabelValues(interf.ID).Add(*interf.Statistics.TxPackets)
 		}
+		if interf.Statistics.RxBroadcast != nil {
+			interfaceRxBroadcastCounterVec.WithLabelValues(interf.ID).Add(*interf.Statistics.RxBroadcast)
+		}
+		if interf.Statistics.RxMulticast != nil {
+			interfaceRxMulticastCounterVec.WithLabelValues(interf.ID).Add(*interf.Statistics.RxMulticast)
+		}
+		if interf.Statistics.TxBroadcast != nil {
+			interfaceTxBroadcastCounterVec.WithLabelValues(interf.ID).Add(*interf.Statistics.TxBroadcast)
+		}
+		if interf.Statistics.TxMulticast != nil {
+			interfaceTxMulticastCounterVec.WithLabelValues(interf.ID).Add(*interf.Statistics.TxMulticast)
+		}
+		if interf.Statistics.RxRate != nil {
+			interfaceRxRateGaugeVec.WithLabelValues(interf.ID).Set(*interf.Statistics.RxRate)
+		}
+		if interf.Statistics.TxRate != nil {
+			interfaceTxRateGaugeVec.WithLabelValues(interf.ID).Set(*interf.Statistics.TxRate)
+		}
 
 		if interf.Statistics.SFP != nil {
 			if interf.Statistics.SFP.RxPower != nil {
@@ -159,6 +258,15 @@ func addMetricsOltInterfaces(registry prometheus.Registerer, statisticsInterface
 			if interf.Statistics.SFP.Temperature != nil {
 				interfaceSfpTemperatureGaugeVec.WithLabelValues(interf.ID).Set(*interf.Statistics.SFP.Temperature)
 			}
+			if interf.Statistics.SFP.TxPower != nil {
+				interfaceTxPowerGaugeVec.WithLabelValues(interf.ID).Set(*interf.Statistics.SFP.TxPower)
+			}
+			if interf.Statistics.SFP.Current != nil {
+				interfaceSfpCurrentGaugeVec.WithLabelValues(interf.ID).Set(naming.milli(*interf.Statistics.SFP.Current))
+			}
+			if interf.Statistics.SFP.Voltage != nil {
+				interfaceSfpVoltageGaugeVec.WithLabelValues(interf.ID).Set(*interf.Statistics.SFP.Voltage)
+			}
 		}
 
 		name := interf.Name
@@ -170,16 +278,66 @@ func addMetricsOltInterfaces(registry prometheus.Registerer, statisticsInterface
 
 	interfaceStatusEnabledGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "enabled",
+		Help: "Whether the interface is enabled.",
 	}, []string{"name"})
 	registry.MustRegister(interfaceStatusEnabledGaugeVec)
 	interfaceStatusPluggedGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "plugged",
+		Help: "Whether a cable or module is plugged into the interface.",
 	}, []string{"name"})
 	registry.MustRegister(interfaceStatusPluggedGaugeVec)
+	interfaceInfoGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "info",
+		Help: "Information about the interface.",
+	}, []string{"name", "type", "mac", "mtu", "speed", "current_speed"})
+	registry.MustRegister(interfaceInfoGaugeVec)
+
 	interfaceSfpPresentGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "sfp_present",
+		Help: "Whether an SFP is present in the interface.",
 	}, []string{"name"})
 	registry.MustRegister(interfaceSfpPresentGaugeVec)
+	interfaceSfpInfoGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sfp_info",
+		Help: "Information about the SFP of the interface.",
+	}, []string{"name", "vendor", "part", "serial"})
+	registry.MustRegister(interfaceSfpInfoGaugeVec)
+	interfaceSfpLoSGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sfp_los",
+		Help: "Whether the SFP of the interface reports a loss of signal.",
+	}, []string{"name"})
+	registry.MustRegister(interfaceSfpLoSGaugeVec)
+	interfaceSfpTxFaultGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sfp_tx_fault",
+		Help: "Whether the SFP of the interface reports a transmit fault.",
+	}, []string{"name"})
+	registry.MustRegister(interfaceSfpTxFaultGaugeVec)
+
+	interfaceLAGInfoGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lag_info",
+		Help: "Information about the LAG.",
+	}, []string{"name", "load_balance", "static"})
+	registry.MustRegister(interfaceLAGInfoGaugeVec)
+	interfaceLAGMemberGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lag_member",
+		Help: "Interface that is a member of the LAG.",
+	}, []string{"name", "member"})
+	registry.MustRegister(interfaceLAGMemberGaugeVec)
+	interfaceLAGMembersGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lag_members",
+		Help: "Number of members of the LAG.",
+	}, []string{"name"})
+	registry.MustRegister(interfaceLAGMembersGaugeVec)
+	interfaceLAGMembersUpGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lag_members_up",
+		Help: "Number of members of the LAG that are enabled and plugged.",
+	}, []string{"name"})
+	registry.MustRegister(interfaceLAGMembersUpGaugeVec)
+
+	statusByID := map[string]model.Status{}
+	for _, interf := range interfacesInterfaces {
+		statusByID[interf.Identification.ID] = interf.Status
+	}
 
 	for _, interf := range interfacesInterfaces {
 		var enabled float64 = 0
@@ -194,21 +352,64 @@ func addMetricsOltInterfaces(registry prometheus.Registerer, statisticsInterface
 		}
 		interfaceStatusPluggedGaugeVec.WithLabelValues(interf.Identification.ID).Set(plugged)
 
+		interfaceInfoGaugeVec.WithLabelValues(interf.Identification.ID, interf.Identification.Type, interf.Identification.MAC, strconv.FormatFloat(interf.Status.MTU, 'f', -1, 64), interf.Status.Speed, interf.Status.CurrentSpeed).Set(1)
+
+		var sfp *model.SfpModule
 		if interf.Port != nil {
+			sfp = &interf.Port.SFP
+		}
+		if interf.PON != nil {
+			sfp = &interf.PON.SFP
+		}
+		if sfp != nil {
 			var present float64 = 0
-			if interf.Port.SFP.Present {
+			if sfp.Present {
 				present = 1
 			}
 			interfaceSfpPresentGaugeVec.WithLabelValues(interf.Identification.ID).Set(present)
+
+			if sfp.Present {
+				interfaceSfpInfoGaugeVec.WithLabelValues(interf.Identification.ID, stringValue(sfp.Vendor), sfp.Part, stringValue(sfp.Serial)).Set(1)
+			}
+			if sfp.LoS != nil {
+				var los float64 = 0
+				if *sfp.LoS {
+					los = 1
+				}
+				interfaceSfpLoSGaugeVec.WithLabelValues(interf.Identification.ID).Set(los)
+			}
+			// the API reports the tx fault as string
+			if sfp.TxFault != nil {
+				if txFault, err := strconv.ParseBool(*sfp.TxFault); err == nil {
+					var fault float64 = 0
+					if txFault {
+						fault = 1
+					}
+					interfaceSfpTxFaultGaugeVec.WithLabelValues(interf.Identification.ID).Set(fault)
+				}
+			}
 		}
 
-		if interf.PON != nil {
-			var present float64 = 0
-			if interf.PON.SFP.Present {
-				present = 1
+		if interf.LAG != nil {
+			interfaceLAGInfoGaugeVec.WithLabelValues(interf.Identification.ID, interf.LAG.LoadBalance, strconv.FormatBool(interf.LAG.Static)).Set(1)
+
+			var up float64
+			for _, member := range interf.LAG.Interfaces {
+				interfaceLAGMemberGaugeVec.WithLabelValues(interf.Identification.ID, member.ID).Set(1)
+				if status, ok := statusByID[member.ID]; ok && status.Enabled && status.Plugged {
+					up++
+				}
 			}
-			interfaceSfpPresentGaugeVec.WithLabelValues(interf.Identification.ID).Set(present)
+			interfaceLAGMembersGaugeVec.WithLabelValues(interf.Identification.ID).Set(float64(len(interf.LAG.Interfaces)))
+			interfaceLAGMembersUpGaugeVec.WithLabelValues(interf.Identification.ID).Set(up)
 		}
 	}
 	return nil
 }
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
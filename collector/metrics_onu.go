@@ -7,92 +7,140 @@ import (
 	"github.com/swoga/ufiber-exporter/model"
 )
 
-func AddMetricsOnu(registry prometheus.Registerer, onus []model.ONU, onussettings []model.ONUSettings) error {
+func AddMetricsOnu(registry prometheus.Registerer, naming Naming, onus []model.ONU, onussettings []model.ONUSettings) error {
 	authorizedGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "authorized",
+		Help: "Whether the ONU is authorized.",
 	}, []string{"serial"})
 	registry.MustRegister(authorizedGaugeVec)
 	connectedGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "connected",
+		Help: "Whether the ONU is connected.",
 	}, []string{"serial"})
 	registry.MustRegister(connectedGaugeVec)
-	connectionTimeCounterVec := prometheus.NewCounterVec(prometheus.CounterOpts{
-		Name: "connection_time",
-	}, []string{"serial"})
-	registry.MustRegister(connectionTimeCounterVec)
+	connectionTime := newValueVec(registry, naming, prometheus.GaugeOpts{
+		Name: "connection_time_seconds",
+		Help: "Time since the ONU connected.",
+	}, "connection_time", []string{"serial"})
 	distanceGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "distance",
+		Name: naming.name("distance_meters", "distance"),
+		Help: "Distance of the ONU to the OLT.",
 	}, []string{"serial"})
 	registry.MustRegister(distanceGaugeVec)
 	oltPortGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "pon",
+		Name: naming.name("pon_info", "pon"),
+		Help: "PON port of the OLT the ONU is connected to.",
 	}, []string{"serial", "pon"})
 	registry.MustRegister(oltPortGaugeVec)
 	infoGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "info",
-	}, []string{"serial", "firmware_version", "mac", "error", "dying_gasp", "given_name", "model", "mode"})
+		Help: "Information about the ONU.",
+	}, []string{"serial", "firmware_version", "firmware_hash", "mac", "error", "dying_gasp", "given_name", "model", "mode"})
 	registry.MustRegister(infoGaugeVec)
 
 	rxPowerGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "rx_power",
+		Name: naming.name("rx_power_dbm", "rx_power"),
+		Help: "Receive power of the ONU.",
 	}, []string{"serial"})
 	registry.MustRegister(rxPowerGaugeVec)
 	txPowerGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "tx_power",
+		Name: naming.name("tx_power_dbm", "tx_power"),
+		Help: "Transmit power of the ONU.",
 	}, []string{"serial"})
 	registry.MustRegister(txPowerGaugeVec)
+	laserBiasGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: naming.name("laser_bias_current_amperes", "laser_bias"),
+		Help: "Laser bias current of the ONU.",
+	}, []string{"serial"})
+	registry.MustRegister(laserBiasGaugeVec)
 
 	portPluggedGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "port_plugged",
+		Help: "Whether a cable is plugged into the port of the ONU.",
 	}, []string{"serial", "name"})
 	registry.MustRegister(portPluggedGaugeVec)
 	portRxBytesCounterVec := prometheus.NewCounterVec(prometheus.CounterOpts{
-		Name: "port_rx_bytes",
+		Name: naming.name("port_rx_bytes_total", "port_rx_bytes"),
+		Help: "Received bytes of the port of the ONU.",
 	}, []string{"serial", "name"})
 	registry.MustRegister(portRxBytesCounterVec)
 	portTxBytesCounterVec := prometheus.NewCounterVec(prometheus.CounterOpts{
-		Name: "port_tx_bytes",
+		Name: naming.name("port_tx_bytes_total", "port_tx_bytes"),
+		Help: "Transmitted bytes of the port of the ONU.",
 	}, []string{"serial", "name"})
 	registry.MustRegister(portTxBytesCounterVec)
+	portRxRateGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: naming.name("port_rx_rate_bits_per_second", "port_rx_rate"),
+		Help: "Receive rate of the port of the ONU.",
+	}, []string{"serial", "name"})
+	registry.MustRegister(portRxRateGaugeVec)
+	portTxRateGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: naming.name("port_tx_rate_bits_per_second", "port_tx_rate"),
+		Help: "Transmit rate of the port of the ONU.",
+	}, []string{"serial", "name"})
+	registry.MustRegister(portTxRateGaugeVec)
 	portInfoGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "port_info",
+		Help: "Information about the port of the ONU.",
 	}, []string{"serial", "name", "speed"})
 	registry.MustRegister(portInfoGaugeVec)
 
 	rxBytesCounterVec := prometheus.NewCounterVec(prometheus.CounterOpts{
-		Name: "rx_bytes",
+		Name: naming.name("rx_bytes_total", "rx_bytes"),
+		Help: "Received bytes of the ONU.",
 	}, []string{"serial"})
 	registry.MustRegister(rxBytesCounterVec)
 	txBytesCounterVec := prometheus.NewCounterVec(prometheus.CounterOpts{
-		Name: "tx_bytes",
+		Name: naming.name("tx_bytes_total", "tx_bytes"),
+		Help: "Transmitted bytes of the ONU.",
 	}, []string{"serial"})
 	registry.MustRegister(txBytesCounterVec)
+	rxRateGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: naming.name("rx_rate_bits_per_second", "rx_rate"),
+		Help: "Receive rate of the ONU.",
+	}, []string{"serial"})
+	registry.MustRegister(rxRateGaugeVec)
+	txRateGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: naming.name("tx_rate_bits_per_second", "tx_rate"),
+		Help: "Transmit rate of the ONU.",
+	}, []string{"serial"})
+	registry.MustRegister(txRateGaugeVec)
 
 	systemCPUGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "cpu",
+		Name: naming.name("cpu_usage_ratio", "cpu"),
+		Help: "CPU usage of the ONU.",
 	}, []string{"serial"})
 	registry.MustRegister(systemCPUGaugeVec)
 	systemMemGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "memory",
+		Name: naming.name("memory_usage_ratio", "memory"),
+		Help: "Memory usage of the ONU.",
 	}, []string{"serial"})
 	registry.MustRegister(systemMemGaugeVec)
 	systemTempGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "temperature",
+		Name: naming.name("temperature_celsius", "temperature"),
+		Help: "Temperature of the sensor of the ONU.",
 	}, []string{"serial", "sensor"})
 	registry.MustRegister(systemTempGaugeVec)
-	systemUptimeCounterVec := prometheus.NewCounterVec(prometheus.CounterOpts{
-		Name: "uptime",
-	}, []string{"serial"})
-	registry.MustRegister(systemUptimeCounterVec)
+	systemUptime := newValueVec(registry, naming, prometheus.GaugeOpts{
+		Name: "uptime_seconds",
+		Help: "Uptime of the ONU.",
+	}, "uptime", []string{"serial"})
 	systemVoltageGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "voltage",
+		Name: naming.name("voltage_volts", "voltage"),
+		Help: "Supply voltage of the ONU.",
 	}, []string{"serial"})
 	registry.MustRegister(systemVoltageGaugeVec)
 
 	upgradeStatusGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "upgrade_status",
+		Help: "Firmware upgrade status of the ONU: 2 in progress, 1 finished, 0 failed, -1 unknown.",
 	}, []string{"serial"})
 	registry.MustRegister(upgradeStatusGaugeVec)
+	upgradeFailureReasonGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "upgrade_failure_reason",
+		Help: "Reason of the failed firmware upgrade of the ONU.",
+	}, []string{"serial", "reason"})
+	registry.MustRegister(upgradeFailureReasonGaugeVec)
 
 	var onuSettingsMap = map[string]model.ONUSettings{}
 	for _, onusettings := range onussettings {
@@ -100,11 +148,9 @@ func AddMetricsOnu(registry prometheus.Registerer, onus []model.ONU, onussetting
 	}
 
 	for _, onu := range onus {
-		onusettings, ok := onuSettingsMap[onu.Serial]
-		if !ok {
-			return fmt.Errorf("settings for ONU %s not found", onu.Serial)
-		}
-		infoGaugeVec.WithLabelValues(onu.Serial, onu.FirmwareVersion, onu.MAC, onu.Error, onu.DyingGasp, onusettings.Name, onusettings.Model, onusettings.Mode).Set(1)
+		// settings can be missing for an ONU that appeared between the requests, export it with empty labels
+		onusettings := onuSettingsMap[onu.Serial]
+		infoGaugeVec.WithLabelValues(onu.Serial, onu.FirmwareVersion, onu.FirmwareHash, onu.MAC, onu.Error, onu.DyingGasp, onusettings.Name, onusettings.Model, onusettings.Mode).Set(1)
 
 		var connected float64
 		if onu.Connected {
@@ -119,7 +165,7 @@ func AddMetricsOnu(registry prometheus.Registerer, onus []model.ONU, onussetting
 			authorizedGaugeVec.WithLabelValues(onu.Serial).Set(authorized)
 		}
 		if onu.ConnectionTime != nil {
-			connectionTimeCounterVec.WithLabelValues(onu.Serial).Add(*onu.ConnectionTime)
+			connectionTime.set(*onu.ConnectionTime, onu.Serial)
 		}
 		if onu.Distance != nil {
 			distanceGaugeVec.WithLabelValues(onu.Serial).Set(*onu.Distance)
@@ -133,37 +179,44 @@ func AddMetricsOnu(registry prometheus.Registerer, onus []model.ONU, onussetting
 		if onu.TxPower != nil {
 			txPowerGaugeVec.WithLabelValues(onu.Serial).Set(*onu.TxPower)
 		}
+		if onu.LaserBias != nil {
+			laserBiasGaugeVec.WithLabelValues(onu.Serial).Set(naming.milli(*onu.LaserBias))
+		}
 		if onu.Statistics != nil {
 			rxBytesCounterVec.WithLabelValues(onu.Serial).Add(onu.Statistics.RxBytes)
 			txBytesCounterVec.WithLabelValues(onu.Serial).Add(onu.Statistics.TxBytes)
+			rxRateGaugeVec.WithLabelValues(onu.Serial).Set(onu.Statistics.RxRate)
+			txRateGaugeVec.WithLabelValues(onu.Serial).Set(onu.Statistics.TxRate)
 		}
 		if onu.Ports != nil {
 			for i, port := range *onu.Ports {
-				var portStat model.ONUStatistics
-				if onu.PortsStat != nil {
-					portsStat := *onu.PortsStat
-					portStat = portsStat[i]
-				}
-
 				var plugged float64
 				if port.Plugged {
 					plugged = 1
 				}
 				portPluggedGaugeVec.WithLabelValues(onu.Serial, port.ID).Set(plugged)
 
-				portRxBytesCounterVec.WithLabelValues(onu.Serial, port.ID).Add(portStat.RxBytes)
-				portTxBytesCounterVec.WithLabelValues(onu.Serial, port.ID).Add(portStat.TxBytes)
+				// portsStat is not reported by all ONUs
+				if onu.PortsStat != nil && i < len(*onu.PortsStat) {
+					portStat := (*onu.PortsStat)[i]
+					portRxBytesCounterVec.WithLabelValues(onu.Serial, port.ID).Add(portStat.RxBytes)
+					portTxBytesCounterVec.WithLabelValues(onu.Serial, port.ID).Add(portStat.TxBytes)
+					portRxRateGaugeVec.WithLabelValues(onu.Serial, port.ID).Set(portStat.RxRate)
+					portTxRateGaugeVec.WithLabelValues(onu.Serial, port.ID).Set(portStat.TxRate)
+				}
 				portInfoGaugeVec.WithLabelValues(onu.Serial, port.ID, port.Speed).Set(1)
 			}
 		}
 		if onu.System != nil {
-			systemCPUGaugeVec.WithLabelValues(onu.Serial).Set(onu.System.CPU)
-			systemMemGaugeVec.WithLabelValues(onu.Serial).Set(onu.System.Mem)
+			systemCPUGaugeVec.WithLabelValues(onu.Serial).Set(naming.percent(onu.System.CPU))
+			systemMemGaugeVec.WithLabelValues(onu.Serial).Set(naming.percent(onu.System.Mem))
 			for sensor, value := range onu.System.Temperature {
 				systemTempGaugeVec.WithLabelValues(onu.Serial, sensor).Set(value)
 			}
-			systemUptimeCounterVec.WithLabelValues(onu.Serial).Add(onu.System.Uptime)
+			systemUptime.set(onu.System.Uptime, onu.Serial)
 			systemVoltageGaugeVec.WithLabelValues(onu.Serial).Set(onu.System.Voltage)
+		}
+		if onu.UpgradeStatus != nil {
 			var upgradeStatus float64
 			switch onu.UpgradeStatus.Status {
 			case "in_progress":
@@ -176,6 +229,9 @@ func AddMetricsOnu(registry prometheus.Registerer, onus []model.ONU, onussetting
 				upgradeStatus = -1
 			}
 			upgradeStatusGaugeVec.WithLabelValues(onu.Serial).Set(upgradeStatus)
+			if onu.UpgradeStatus.FailureReason != "" {
+				upgradeFailureReasonGaugeVec.WithLabelValues(onu.Serial, onu.UpgradeStatus.FailureReason).Set(1)
+			}
 		}
 	}
 
@@ -185,6 +241,7 @@ func AddMetricsOnu(registry prometheus.Registerer, onus []model.ONU, onussetting
 func AddMetricsOnuMACTable(registry prometheus.Registerer, macTable []model.MACTable) error {
 	macGaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "fdb",
+		Help: "MAC address learned behind the ONU.",
 	}, []string{"serial", "mac"})
 	registry.MustRegister(macGaugeVec)
 
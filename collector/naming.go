@@ -0,0 +1,94 @@
+package collector
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Naming selects the names of the exported metrics
+type Naming int
+
+const (
+	// NamingV2 follows the Prometheus naming conventions: values in base units, unit suffixes and _total for counters
+	NamingV2 Naming = iota
+	// NamingLegacy keeps the names and values from before v2, so existing dashboards keep working
+	NamingLegacy
+)
+
+// name returns the name of a metric for the naming
+func (n Naming) name(v2 string, legacy string) string {
+	if n == NamingLegacy {
+		return legacy
+	}
+	return v2
+}
+
+// percent converts a percentage to the ratio of v2
+func (n Naming) percent(value float64) float64 {
+	if n == NamingLegacy {
+		return value
+	}
+	return value / 100
+}
+
+// milli converts a value in a milli unit, like mA, to the base unit of v2
+func (n Naming) milli(value float64) float64 {
+	if n == NamingLegacy {
+		return value
+	}
+	return value / 1000
+}
+
+// valueVec is a gauge, that was exported as counter with the legacy naming
+type valueVec struct {
+	gauge   *prometheus.GaugeVec
+	counter *prometheus.CounterVec
+}
+
+func newValueVec(registry prometheus.Registerer, naming Naming, opts prometheus.GaugeOpts, legacyName string, labelNames []string) valueVec {
+	var v valueVec
+	if naming == NamingLegacy {
+		v.counter = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: legacyName,
+			Help: opts.Help,
+		}, labelNames)
+		registry.MustRegister(v.counter)
+	} else {
+		v.gauge = prometheus.NewGaugeVec(opts, labelNames)
+		registry.MustRegister(v.gauge)
+	}
+	return v
+}
+
+func (v valueVec) set(value float64, labelValues ...string) {
+	if v.counter != nil {
+		v.counter.WithLabelValues(labelValues...).Add(value)
+		return
+	}
+	v.gauge.WithLabelValues(labelValues...).Set(value)
+}
+
+// units are the suffixes of the v2 names, that are written as UNIT in the OpenMetrics format
+var units = []string{"amperes", "bits_per_second", "bytes", "celsius", "dbm", "meters", "ratio", "rpm", "seconds", "volts", "watts"}
+
+// WithUnits sets the unit of all metrics gathered by gatherer, whose name ends with a known unit
+func WithUnits(gatherer prometheus.Gatherer) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		mfs, err := gatherer.Gather()
+		for _, mf := range mfs {
+			name := mf.GetName()
+			if mf.GetType() == dto.MetricType_COUNTER {
+				name = strings.TrimSuffix(name, "_total")
+			}
+			for _, unit := range units {
+				if strings.HasSuffix(name, "_"+unit) {
+					mf.Unit = &unit
+					break
+				}
+			}
+		}
+		return mfs, err
+	})
+}
@@ -11,10 +11,28 @@ type Config struct {
 	ProbePath   string    `yaml:"probe_path"`
 	MetricsPath string    `yaml:"metrics_path"`
 	Timeout     float64   `yaml:"timeout"`
+	TokenFile   string    `yaml:"token_file"`
 	Devices     []*Device `yaml:"devices"`
 	Global      Global    `yaml:"global"`
 
-	deviceMap map[string]*Device
+	SecretProviders map[string]SecretProviderConfig `yaml:"secret_providers"`
+	Profiles        map[string]*Profile             `yaml:"profiles"`
+	// Include lists files with additional devices, as globs relative to the config file or directories
+	Include []string `yaml:"include"`
+
+	deviceMap       map[string]*Device
+	secretProviders map[string]SecretProvider
+	// file is the main config file and files all files the config was loaded from
+	file  string
+	files []string
+	// secretFiles are the files the secrets were read from
+	secretFiles []string
+	// tlsFiles are the CA files of the TLS configs
+	tlsFiles []string
+	// includeDirs are the directories searched for included files
+	includeDirs []string
+	// deviceSources are the files and positions the devices were loaded from, by index in Devices
+	deviceSources []deviceSource
 }
 
 func DefaultConfig() Config {
@@ -24,7 +42,10 @@ func DefaultConfig() Config {
 		MetricsPath: "/metrics",
 		Timeout:     60,
 		Global: Global{
-			Options: DefaultOptions(),
+			Options:     DefaultOptions(),
+			Concurrency: 3,
+			TLSConfig:   DefaultTLSConfig(),
+			HTTPConfig:  DefaultHTTPConfig(),
 		},
 		deviceMap: make(map[string]*Device),
 	}
@@ -38,6 +59,20 @@ func DefaultOptions() Options {
 	}
 }
 
+func DefaultTLSConfig() TLSConfig {
+	return TLSConfig{
+		InsecureSkipVerify: true,
+	}
+}
+
+func DefaultHTTPConfig() HTTPConfig {
+	return HTTPConfig{
+		Scheme:         "https",
+		BasePath:       "/api/v1.0/",
+		RequestTimeout: 300,
+	}
+}
+
 func (c *Config) GetDevice(name string) (*Device, bool) {
 	d, found := c.deviceMap[name]
 	return d, found
@@ -51,23 +86,81 @@ func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		return err
 	}
 
+	return nil
+}
+
+// applyGlobal sets all values of the devices that are not set to the ones of their profile or the global ones
+func (c *Config) applyGlobal() {
 	for _, device := range c.Devices {
+		// the options are merged field by field: global, profile and device
+		options := c.Global.Options
+		if profile, ok := c.GetProfile(device.Profile); ok {
+			options = profile.Options.Apply(options)
+			if device.Timeout == nil {
+				device.Timeout = profile.Timeout
+			}
+		}
+		options = device.ConfiguredOptions.Apply(options)
+		device.Options = &options
+		if device.Timeout == nil {
+			device.Timeout = &c.Timeout
+		}
 		if device.Username == nil {
 			device.Username = &c.Global.Username
 		}
 		if device.Password == nil {
 			device.Password = &c.Global.Password
 		}
-		if device.Options == nil {
-			device.Options = &c.Global.Options
+		if device.PollInterval == nil {
+			device.PollInterval = &c.Global.PollInterval
+		}
+		if device.Concurrency == nil {
+			device.Concurrency = &c.Global.Concurrency
+		}
+		if device.TokenMaxAge == nil {
+			device.TokenMaxAge = &c.Global.TokenMaxAge
+		}
+		if device.TLSConfig == nil {
+			device.TLSConfig = &c.Global.TLSConfig
+		}
+		if device.HTTPConfig == nil {
+			device.HTTPConfig = &c.Global.HTTPConfig
+		}
+		if device.DiscoverONUs == nil {
+			device.DiscoverONUs = &c.Global.DiscoverONUs
+		}
+		if device.LegacyMetricNames == nil {
+			device.LegacyMetricNames = &c.Global.LegacyMetricNames
+		}
+		if device.Labels == nil {
+			device.Labels = c.Global.Labels
+		} else {
+			labels := map[string]string{}
+			for name, value := range c.Global.Labels {
+				labels[name] = value
+			}
+			for name, value := range device.Labels {
+				labels[name] = value
+			}
+			device.Labels = labels
 		}
 	}
+}
 
-	if err := c.populateDeviceMap(); err != nil {
-		return nil
-	}
+// Files returns all files the config was loaded from, the main config file first
+func (c *Config) Files() []string {
+	return c.files
+}
 
-	return nil
+// WatchFiles returns all paths whose change can change the config:
+// the config files, the secret files and the directories of the include patterns, so new files are noticed
+func (c *Config) WatchFiles() []string {
+	var files []string
+	files = append(files, c.files...)
+	files = append(files, c.secretFiles...)
+	files = append(files, c.tlsFiles...)
+	files = append(files, c.includeDirs...)
+	return files
 }
 
 func (c *Config) populateDeviceMap() error {
@@ -84,9 +177,24 @@ func (c *Config) populateDeviceMap() error {
 }
 
 type Global struct {
-	Username string  `yaml:"username"`
-	Password string  `yaml:"password"`
-	Options  Options `yaml:"options"`
+	Username       string     `yaml:"username"`
+	UsernameFile   string     `yaml:"username_file"`
+	UsernameSecret *SecretRef `yaml:"username_secret"`
+	Password       Secret     `yaml:"password"`
+	PasswordFile   string     `yaml:"password_file"`
+	PasswordSecret *SecretRef `yaml:"password_secret"`
+	Options        Options    `yaml:"options"`
+	PollInterval   float64    `yaml:"poll_interval"`
+	Concurrency    int        `yaml:"concurrency"`
+	TokenMaxAge    float64    `yaml:"token_max_age"`
+	TLSConfig      TLSConfig  `yaml:"tls_config"`
+	HTTPConfig     HTTPConfig `yaml:"http_config"`
+	// Labels are added to all metrics of the devices
+	Labels map[string]string `yaml:"labels"`
+	// DiscoverONUs serves the ONUs of the devices as targets for service discovery
+	DiscoverONUs bool `yaml:"discover_onus"`
+	// LegacyMetricNames exports the metrics with the names from before v2
+	LegacyMetricNames bool `yaml:"legacy_metric_names"`
 }
 
 type Options struct {
@@ -106,10 +214,85 @@ func (o *Options) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return nil
 }
 
+type TLSConfig struct {
+	CAFile             string `yaml:"ca_file"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+	ServerName         string `yaml:"server_name"`
+	Fingerprint        string `yaml:"fingerprint"`
+	TrustOnFirstUse    bool   `yaml:"trust_on_first_use"`
+	FingerprintFile    string `yaml:"fingerprint_file"`
+
+	// ca is the content of CAFile when the config was loaded, so a changed CA file changes the config
+	ca []byte
+}
+
+func (t *TLSConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*t = DefaultTLSConfig()
+
+	type plain TLSConfig
+	if err := unmarshal((*plain)(t)); err != nil {
+		return err
+	}
+
+	// a CA file or server name is only used to verify the certificate,
+	// so setting one of them turns on verification, unless insecure_skip_verify is set explicitly
+	if t.CAFile != "" || t.ServerName != "" {
+		var fields map[string]interface{}
+		if err := unmarshal(&fields); err != nil {
+			return err
+		}
+		if _, ok := fields["insecure_skip_verify"]; !ok {
+			t.InsecureSkipVerify = false
+		}
+	}
+
+	return nil
+}
+
+type HTTPConfig struct {
+	Scheme        string `yaml:"scheme"`
+	Port          int    `yaml:"port"`
+	BasePath      string `yaml:"base_path"`
+	ProxyURL      string `yaml:"proxy_url"`
+	SourceAddress string `yaml:"source_address"`
+	// RequestTimeout in seconds
+	RequestTimeout float64 `yaml:"request_timeout"`
+}
+
+func (h *HTTPConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*h = DefaultHTTPConfig()
+
+	type plain HTTPConfig
+	if err := unmarshal((*plain)(h)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
 type Device struct {
-	Name     string   `yaml:"name"`
-	Address  string   `yaml:"address"`
-	Username *string  `yaml:"username"`
-	Password *string  `yaml:"password"`
-	Options  *Options `yaml:"options"`
+	Name           string     `yaml:"name"`
+	Address        string     `yaml:"address"`
+	Username       *string    `yaml:"username"`
+	UsernameFile   string     `yaml:"username_file"`
+	UsernameSecret *SecretRef `yaml:"username_secret"`
+	Password       *Secret    `yaml:"password"`
+	PasswordFile   string     `yaml:"password_file"`
+	PasswordSecret *SecretRef `yaml:"password_secret"`
+	// Options are the options of the device merged with the ones of its profile and global
+	Options *Options `yaml:"-"`
+	// ConfiguredOptions are the options set on the device
+	ConfiguredOptions ProfileOptions `yaml:"options"`
+	PollInterval      *float64       `yaml:"poll_interval"`
+	Concurrency       *int           `yaml:"concurrency"`
+	TokenMaxAge       *float64       `yaml:"token_max_age"`
+	TLSConfig         *TLSConfig     `yaml:"tls_config"`
+	HTTPConfig        *HTTPConfig    `yaml:"http_config"`
+	Profile           string         `yaml:"profile"`
+	// Timeout of the probe in seconds
+	Timeout *float64 `yaml:"timeout"`
+	// Labels are added to all metrics of the device, merged with the global labels
+	Labels            map[string]string `yaml:"labels"`
+	DiscoverONUs      *bool             `yaml:"discover_onus"`
+	LegacyMetricNames *bool             `yaml:"legacy_metric_names"`
 }
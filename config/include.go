@@ -0,0 +1,88 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// includeFile is the content of an included file
+type includeFile struct {
+	Devices []*Device `yaml:"devices"`
+}
+
+// deviceSource is the position of a device in the files of the config
+type deviceSource struct {
+	file  string
+	index int
+}
+
+// path of the device in its file, e.g. $.devices[0]
+func (s deviceSource) path() string {
+	return fmt.Sprintf("$.devices[%d]", s.index)
+}
+
+// deviceSource returns where the device at index i of Devices was loaded from
+func (c *Config) deviceSource(i int) deviceSource {
+	if i < len(c.deviceSources) {
+		return c.deviceSources[i]
+	}
+	return deviceSource{file: c.file, index: i}
+}
+
+// describeDevice names the device at index i for errors about the device at other
+func (c *Config) describeDevice(i int, other deviceSource) string {
+	source := c.deviceSource(i)
+	description := strings.TrimPrefix(source.path(), "$.")
+	if source.file != other.file {
+		description += " in " + source.file
+	}
+	return description
+}
+
+// includedFiles returns the files matching the include patterns in order, relative patterns are resolved from dir.
+// A directory includes all its *.yml and *.yaml files.
+// The directories searched are returned as well.
+func includedFiles(dir string, patterns []string) ([]string, []string, error) {
+	var files, dirs []string
+	seen := map[string]bool{}
+	for _, pattern := range patterns {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+
+		var matches []string
+		if info, err := os.Stat(pattern); err == nil && info.IsDir() {
+			dirs = append(dirs, pattern)
+			for _, ext := range []string{"*.yml", "*.yaml"} {
+				m, _ := filepath.Glob(filepath.Join(pattern, ext))
+				matches = append(matches, m...)
+			}
+			sort.Strings(matches)
+		} else {
+			matches, err = filepath.Glob(pattern)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid include pattern %s: %w", pattern, err)
+			}
+			// a glob may match nothing, like an empty conf.d, but a single file has to exist
+			if !strings.ContainsAny(pattern, "*?[") {
+				if len(matches) == 0 {
+					return nil, nil, fmt.Errorf("included file %s does not exist", pattern)
+				}
+			} else {
+				dirs = append(dirs, filepath.Dir(pattern))
+			}
+		}
+
+		for _, match := range matches {
+			if seen[match] {
+				continue
+			}
+			seen[match] = true
+			files = append(files, match)
+		}
+	}
+	return files, dirs, nil
+}
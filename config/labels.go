@@ -0,0 +1,68 @@
+package config
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ReservedLabels are the label names used by the metrics of a probe and the polled snapshots, they can't be used as labels of a device
+var ReservedLabels = map[string]bool{
+	"address":          true,
+	"class":            true,
+	"cpu":              true,
+	"current_speed":    true,
+	"device":           true,
+	"dying_gasp":       true,
+	"endpoint":         true,
+	"error":            true,
+	"fan":              true,
+	"firmware_hash":    true,
+	"firmware_version": true,
+	"given_name":       true,
+	"load_balance":     true,
+	"mac":              true,
+	"member":           true,
+	"mode":             true,
+	"model":            true,
+	"mtu":              true,
+	"name":             true,
+	"part":             true,
+	"pon":              true,
+	"psu":              true,
+	"reason":           true,
+	"section":          true,
+	"sensor":           true,
+	"serial":           true,
+	"speed":            true,
+	"static":           true,
+	"type":             true,
+	"vendor":           true,
+}
+
+// targetLabels are set by Prometheus for each target, a device label would be overwritten or overwrite the instance of the device
+var targetLabels = map[string]bool{
+	"instance": true,
+	"job":      true,
+}
+
+var labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+func (v *validator) validateLabels(path string, labels map[string]string) {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		switch {
+		case !labelNamePattern.MatchString(name) || strings.HasPrefix(name, "__"):
+			v.errorf(path+"."+name, "invalid label name %q", name)
+		case targetLabels[name]:
+			v.errorf(path+"."+name, "label name %q is set by Prometheus for the target", name)
+		case ReservedLabels[name]:
+			v.errorf(path+"."+name, "label name %q is used by the metrics of the exporter", name)
+		}
+	}
+}
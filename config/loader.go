@@ -1,8 +1,12 @@
 package config
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"sync"
 
 	"github.com/goccy/go-yaml"
@@ -48,7 +52,6 @@ func New(configFile string) SafeConfig {
 }
 
 func (sc *SafeConfig) LoadConfig() (err error) {
-	c := &Config{}
 	defer func() {
 		if err != nil {
 			configReloadSuccess.Set(0)
@@ -58,16 +61,9 @@ func (sc *SafeConfig) LoadConfig() (err error) {
 		}
 	}()
 
-	yamlReader, err := os.Open(sc.configFile)
+	c, err := Load(sc.configFile)
 	if err != nil {
-		return fmt.Errorf("error reading config file: %s", err)
-	}
-	defer yamlReader.Close()
-	decoder := yaml.NewDecoder(yamlReader, yaml.Strict())
-
-	err = decoder.Decode(c)
-	if err != nil {
-		return fmt.Errorf("error parsing config file: %s", err)
+		return err
 	}
 
 	sc.Lock()
@@ -76,3 +72,109 @@ func (sc *SafeConfig) LoadConfig() (err error) {
 
 	return nil
 }
+
+// Load reads, parses and validates a config file together with its included files
+func Load(configFile string) (*Config, error) {
+	c := DefaultConfig()
+	contents := map[string][]byte{}
+
+	data, err := readConfigFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+	contents[configFile] = data
+	err = decodeConfigFile(data, &c)
+	if errors.Is(err, io.EOF) {
+		// an empty file uses the defaults
+		c = DefaultConfig()
+	} else if err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %s", configFile, err)
+	}
+	err = expandEnv(&c)
+	if err != nil {
+		return nil, fmt.Errorf("error expanding config file %s: %s", configFile, err)
+	}
+	c.file = configFile
+	c.files = []string{configFile}
+	for i := range c.Devices {
+		c.deviceSources = append(c.deviceSources, deviceSource{configFile, i})
+	}
+
+	includes, includeDirs, err := includedFiles(filepath.Dir(configFile), c.Include)
+	if err != nil {
+		return nil, err
+	}
+	c.includeDirs = includeDirs
+	for _, file := range includes {
+		data, err := readConfigFile(file)
+		if err != nil {
+			return nil, err
+		}
+		contents[file] = data
+		var include includeFile
+		err = decodeConfigFile(data, &include)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("error parsing config file %s: %s", file, err)
+		}
+		err = expandEnv(&include)
+		if err != nil {
+			return nil, fmt.Errorf("error expanding config file %s: %s", file, err)
+		}
+		c.files = append(c.files, file)
+		for i, device := range include.Devices {
+			c.Devices = append(c.Devices, device)
+			c.deviceSources = append(c.deviceSources, deviceSource{file, i})
+		}
+	}
+
+	err = c.resolveSecrets()
+	if err != nil {
+		return nil, err
+	}
+	c.applyGlobal()
+
+	err = c.Validate()
+	if err != nil {
+		annotateLines(err, contents)
+		return nil, fmt.Errorf("invalid config:\n%w", err)
+	}
+	c.loadTLSFiles()
+
+	err = c.populateDeviceMap()
+	if err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// loadTLSFiles reads the CA files of global and all devices, they were already checked by Validate.
+// The fingerprint files are not watched, as the exporter writes them itself.
+func (c *Config) loadTLSFiles() {
+	tlsConfigs := []*TLSConfig{&c.Global.TLSConfig}
+	for _, device := range c.Devices {
+		if device.TLSConfig != &c.Global.TLSConfig {
+			tlsConfigs = append(tlsConfigs, device.TLSConfig)
+		}
+	}
+	for _, tlsConfig := range tlsConfigs {
+		if tlsConfig.CAFile == "" {
+			continue
+		}
+		tlsConfig.ca, _ = os.ReadFile(tlsConfig.CAFile)
+		c.tlsFiles = append(c.tlsFiles, tlsConfig.CAFile)
+	}
+}
+
+func readConfigFile(file string) ([]byte, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %s", err)
+	}
+	return data, nil
+}
+
+func decodeConfigFile(data []byte, v interface{}) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(data), yaml.Strict())
+	return decoder.Decode(v)
+}
@@ -0,0 +1,38 @@
+package config
+
+// Profile bundles settings that devices and probes can reference by name, like the modules of blackbox_exporter.
+// Only the values set in the profile override the ones of the device.
+type Profile struct {
+	Options ProfileOptions `yaml:"options"`
+	// Timeout of the probe in seconds
+	Timeout *float64 `yaml:"timeout"`
+	// ONU and PON limit the ONU metrics like the query parameters onu and pon
+	ONU string `yaml:"onu"`
+	PON string `yaml:"pon"`
+}
+
+// ProfileOptions are Options where each field can be left unset, used by profiles and devices
+type ProfileOptions struct {
+	ExportOLT      *bool `yaml:"export_olt"`
+	ExportONUs     *bool `yaml:"export_onus"`
+	ExportMACTable *bool `yaml:"export_mac_table"`
+}
+
+// Apply returns options with the fields set in the profile overridden
+func (p ProfileOptions) Apply(options Options) Options {
+	if p.ExportOLT != nil {
+		options.ExportOLT = *p.ExportOLT
+	}
+	if p.ExportONUs != nil {
+		options.ExportONUs = *p.ExportONUs
+	}
+	if p.ExportMACTable != nil {
+		options.ExportMACTable = *p.ExportMACTable
+	}
+	return options
+}
+
+func (c *Config) GetProfile(name string) (*Profile, bool) {
+	p, found := c.Profiles[name]
+	return p, found && p != nil
+}
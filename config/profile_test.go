@@ -0,0 +1,72 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProfile(t *testing.T) {
+	c, err := loadConfig(t, `timeout: 30
+profiles:
+  light:
+    options:
+      export_onus: false
+    timeout: 10
+global:
+  username: ubnt
+  password: ubnt
+  options:
+    export_mac_table: true
+devices:
+  - name: olt1
+    address: olt1
+    profile: light
+  - name: olt2
+    address: olt2
+    profile: light
+    options:
+      export_onus: true
+    timeout: 20
+  - name: olt3
+    address: olt3
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	olt1, _ := c.GetDevice("olt1")
+	if *olt1.Options != (Options{ExportOLT: true, ExportONUs: false, ExportMACTable: true}) || *olt1.Timeout != 10 {
+		t.Errorf("expected profile merged into global options, got %+v with timeout %v", *olt1.Options, *olt1.Timeout)
+	}
+	olt2, _ := c.GetDevice("olt2")
+	if *olt2.Options != (Options{ExportOLT: true, ExportONUs: true, ExportMACTable: true}) || *olt2.Timeout != 20 {
+		t.Errorf("expected values of the device to take precedence field by field, got %+v with timeout %v", *olt2.Options, *olt2.Timeout)
+	}
+	olt3, _ := c.GetDevice("olt3")
+	if *olt3.Options != c.Global.Options || *olt3.Timeout != 30 {
+		t.Errorf("expected global values without profile, got %+v with timeout %v", *olt3.Options, *olt3.Timeout)
+	}
+}
+
+func TestProfileUnknown(t *testing.T) {
+	_, err := loadConfig(t, `devices:
+  - name: olt1
+    address: olt1
+    username: ubnt
+    password: ubnt
+    profile: missing
+`)
+	if err == nil || !strings.Contains(err.Error(), `line 6: $.devices[0].profile: unknown profile "missing"`) {
+		t.Errorf("expected unknown profile error, got %v", err)
+	}
+}
+
+func TestProfileInvalidPON(t *testing.T) {
+	_, err := loadConfig(t, `profiles:
+  pon:
+    pon: first
+`)
+	if err == nil || !strings.Contains(err.Error(), `line 3: $.profiles.pon.pon: invalid PON "first", expected its number`) {
+		t.Errorf("expected invalid PON error, got %v", err)
+	}
+}
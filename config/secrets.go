@@ -0,0 +1,272 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Secret is a string that is never printed, e.g. by debug logging
+type Secret string
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return "<secret>"
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + s.String() + `"`), nil
+}
+
+// SecretRef references a secret of a configured secret provider
+type SecretRef struct {
+	Provider string `yaml:"provider"`
+	Name     string `yaml:"name"`
+}
+
+type SecretProviderConfig struct {
+	Type    string            `yaml:"type"`
+	Path    string            `yaml:"path"`
+	Options map[string]string `yaml:"options"`
+}
+
+// SecretProvider resolves the name of a secret to its value
+type SecretProvider interface {
+	Secret(name string) (string, error)
+}
+
+// SecretProviderFactory creates a provider from its configuration
+type SecretProviderFactory func(config SecretProviderConfig) (SecretProvider, error)
+
+var (
+	secretProviderTypesMutex sync.RWMutex
+	secretProviderTypes      = map[string]SecretProviderFactory{
+		"directory": newDirectorySecretProvider,
+	}
+)
+
+// RegisterSecretProviderType makes a secret provider type available for the configuration
+func RegisterSecretProviderType(typ string, factory SecretProviderFactory) {
+	secretProviderTypesMutex.Lock()
+	defer secretProviderTypesMutex.Unlock()
+	secretProviderTypes[typ] = factory
+}
+
+func newSecretProvider(config SecretProviderConfig) (SecretProvider, error) {
+	secretProviderTypesMutex.RLock()
+	factory, ok := secretProviderTypes[config.Type]
+	secretProviderTypesMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown secret provider type: %s", config.Type)
+	}
+	return factory(config)
+}
+
+// fileSecretProvider is implemented by secret providers that read their secrets from files, which can be watched for changes
+type fileSecretProvider interface {
+	secretFile(name string) string
+}
+
+// directorySecretProvider reads each secret from a file in a directory, like mounted Kubernetes secrets or Vault agent templates
+type directorySecretProvider struct {
+	path string
+}
+
+func newDirectorySecretProvider(config SecretProviderConfig) (SecretProvider, error) {
+	if config.Path == "" {
+		return nil, errors.New("directory secret provider requires a path")
+	}
+	return &directorySecretProvider{path: config.Path}, nil
+}
+
+func (p *directorySecretProvider) Secret(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || name == ".." {
+		return "", fmt.Errorf("invalid secret name: %s", name)
+	}
+	return readSecretFile(p.secretFile(name))
+}
+
+func (p *directorySecretProvider) secretFile(name string) string {
+	return filepath.Join(p.path, name)
+}
+
+// readSecretFile returns the content of file without trailing newlines
+func readSecretFile(file string) (string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+var envPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces all ${NAME} in the decoded string values of v with the value of the environment variable,
+// undefined variables are an error. The values are expanded after parsing, so they can contain any character.
+func expandEnv(v interface{}) error {
+	var missing []string
+	expandEnvValue(reflect.ValueOf(v), &missing)
+	if len(missing) > 0 {
+		return fmt.Errorf("undefined environment variables: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func expandEnvValue(v reflect.Value, missing *[]string) {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if !v.IsNil() {
+			expandEnvValue(v.Elem(), missing)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				expandEnvValue(v.Field(i), missing)
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			expandEnvValue(v.Index(i), missing)
+		}
+	case reflect.Map:
+		// map values are not addressable, expand a copy and store it
+		for _, key := range v.MapKeys() {
+			value := reflect.New(v.Type().Elem()).Elem()
+			value.Set(v.MapIndex(key))
+			expandEnvValue(value, missing)
+			v.SetMapIndex(key, value)
+		}
+	case reflect.String:
+		if v.CanSet() {
+			v.SetString(expandEnvString(v.String(), missing))
+		}
+	}
+}
+
+func expandEnvString(s string, missing *[]string) string {
+	return envPattern.ReplaceAllStringFunc(s, func(match string) string {
+		name := envPattern.FindStringSubmatch(match)[1]
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			*missing = append(*missing, name)
+		}
+		return value
+	})
+}
+
+// credentials are the alternative sources of a username or password, at most one may be set
+type credentials struct {
+	field  string
+	value  *string
+	file   string
+	secret *SecretRef
+}
+
+func (c *Config) resolveCredential(cred credentials) (*string, error) {
+	sources := 0
+	for _, set := range []bool{cred.value != nil, cred.file != "", cred.secret != nil} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return nil, fmt.Errorf("only one of %[1]s, %[1]s_file and %[1]s_secret can be set", cred.field)
+	}
+
+	switch {
+	case cred.file != "":
+		c.secretFiles = append(c.secretFiles, cred.file)
+		value, err := readSecretFile(cred.file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s_file: %w", cred.field, err)
+		}
+		return &value, nil
+	case cred.secret != nil:
+		provider, ok := c.secretProviders[cred.secret.Provider]
+		if !ok {
+			return nil, fmt.Errorf("unknown secret provider in %s_secret: %s", cred.field, cred.secret.Provider)
+		}
+		if fileProvider, ok := provider.(fileSecretProvider); ok {
+			c.secretFiles = append(c.secretFiles, fileProvider.secretFile(cred.secret.Name))
+		}
+		value, err := provider.Secret(cred.secret.Name)
+		if err != nil {
+			return nil, fmt.Errorf("error getting %s_secret: %w", cred.field, err)
+		}
+		return &value, nil
+	}
+	return cred.value, nil
+}
+
+// resolveSecrets sets the username and password of global and all devices from their files and secret providers
+func (c *Config) resolveSecrets() error {
+	c.secretProviders = map[string]SecretProvider{}
+	for name, providerConfig := range c.SecretProviders {
+		provider, err := newSecretProvider(providerConfig)
+		if err != nil {
+			return fmt.Errorf("secret provider %s: %w", name, err)
+		}
+		c.secretProviders[name] = provider
+	}
+
+	username := c.Global.Username
+	password := string(c.Global.Password)
+	resolved, err := c.resolveCredential(credentials{"username", nilIfEmpty(username), c.Global.UsernameFile, c.Global.UsernameSecret})
+	if err != nil {
+		return fmt.Errorf("global: %w", err)
+	}
+	if resolved != nil {
+		c.Global.Username = *resolved
+	}
+	resolved, err = c.resolveCredential(credentials{"password", nilIfEmpty(password), c.Global.PasswordFile, c.Global.PasswordSecret})
+	if err != nil {
+		return fmt.Errorf("global: %w", err)
+	}
+	if resolved != nil {
+		c.Global.Password = Secret(*resolved)
+	}
+
+	for i, device := range c.Devices {
+		name := "device " + device.Name
+		if file := c.deviceSource(i).file; file != c.file {
+			name += " in " + file
+		}
+
+		resolved, err := c.resolveCredential(credentials{"username", device.Username, device.UsernameFile, device.UsernameSecret})
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		device.Username = resolved
+
+		var password *string
+		if device.Password != nil {
+			value := string(*device.Password)
+			password = &value
+		}
+		resolved, err = c.resolveCredential(credentials{"password", password, device.PasswordFile, device.PasswordSecret})
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		device.Password = nil
+		if resolved != nil {
+			secret := Secret(*resolved)
+			device.Password = &secret
+		}
+	}
+
+	return nil
+}
+
+func nilIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
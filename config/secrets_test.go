@@ -0,0 +1,134 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func loadConfig(t *testing.T, configYAML string) (*Config, error) {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), "config.yml")
+	err := os.WriteFile(file, []byte(configYAML), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sc := New(file)
+	err = sc.LoadConfig()
+	return sc.Get(), err
+}
+
+func TestSecrets(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "password"), []byte("from-file\n"), 0o600)
+	os.Mkdir(filepath.Join(dir, "secrets"), 0o700)
+	os.WriteFile(filepath.Join(dir, "secrets", "olt2"), []byte("from-provider"), 0o600)
+	t.Setenv("OLT_USERNAME", "from-env")
+
+	c, err := loadConfig(t, fmt.Sprintf(`secret_providers:
+  vault:
+    type: directory
+    path: %[1]s/secrets
+global:
+  username: ${OLT_USERNAME}
+  password_file: %[1]s/password
+devices:
+  - name: olt1
+    address: olt1
+  - name: olt2
+    address: olt2
+    password_secret:
+      provider: vault
+      name: olt2
+`, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	olt1, _ := c.GetDevice("olt1")
+	olt2, _ := c.GetDevice("olt2")
+	if *olt1.Username != "from-env" || *olt2.Username != "from-env" {
+		t.Errorf("expected username from environment, got %s and %s", *olt1.Username, *olt2.Username)
+	}
+	if *olt1.Password != "from-file" {
+		t.Errorf("expected password from file, got %s", string(*olt1.Password))
+	}
+	if *olt2.Password != "from-provider" {
+		t.Errorf("expected password from provider, got %s", string(*olt2.Password))
+	}
+
+	watched := strings.Join(c.WatchFiles(), "\n")
+	for _, file := range []string{filepath.Join(dir, "password"), filepath.Join(dir, "secrets", "olt2")} {
+		if !strings.Contains(watched, file) {
+			t.Errorf("expected secret file %s to be watched, got %s", file, watched)
+		}
+	}
+
+	data, _ := json.Marshal(olt2)
+	if strings.Contains(string(data), "from-provider") {
+		t.Errorf("password is not redacted: %s", data)
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	password := "p#ss: 'x\" ${NOT_EXPANDED}\nline2"
+	t.Setenv("OLT_PASSWORD", password)
+	t.Setenv("OLT_SITE", "zrh")
+	t.Setenv("SECRETS_DIR", "/run/secrets")
+
+	c, err := loadConfig(t, `# the password is set by ${OLT_PASSWORD}, ${UNDEFINED} in a comment is no error
+secret_providers:
+  vault:
+    type: directory
+    path: ${SECRETS_DIR}
+global:
+  username: ubnt
+  password: ${OLT_PASSWORD}
+  labels:
+    site: ${OLT_SITE}
+devices:
+  - name: olt1
+    address: olt1
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	olt1, _ := c.GetDevice("olt1")
+	if string(*olt1.Password) != password {
+		t.Errorf("expected password %q, got %q", password, string(*olt1.Password))
+	}
+	if olt1.Labels["site"] != "zrh" {
+		t.Errorf("expected label from environment, got %q", olt1.Labels["site"])
+	}
+	if path := c.SecretProviders["vault"].Path; path != "/run/secrets" {
+		t.Errorf("expected secret provider path from environment, got %q", path)
+	}
+
+	_, err = loadConfig(t, `global:
+  username: ${UNDEFINED}
+`)
+	if err == nil || !strings.Contains(err.Error(), "undefined environment variables: UNDEFINED") {
+		t.Errorf("expected error for undefined variable, got %v", err)
+	}
+}
+
+func TestSecretsInvalid(t *testing.T) {
+	for _, configYAML := range []string{
+		"global:\n  username: ${UFIBER_EXPORTER_UNDEFINED}\n",
+		"global:\n  password: a\n  password_file: b\n",
+		"global:\n  password_file: /nonexistent\n",
+		"global:\n  password_secret:\n    provider: unknown\n    name: a\n",
+		"secret_providers:\n  vault:\n    type: directory\n    path: /tmp\nglobal:\n  password_secret:\n    provider: vault\n    name: ../etc/passwd\n",
+	} {
+		_, err := loadConfig(t, configYAML)
+		if err == nil {
+			t.Errorf("expected error for config:\n%s", configYAML)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package config
+
+import (
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/goccy/go-yaml/ast"
+	"github.com/goccy/go-yaml/parser"
+)
+
+// ValidationError describes an invalid value in the configuration
+type ValidationError struct {
+	// File the value was loaded from, empty if unknown
+	File string
+	// Path of the value in the YAML document, e.g. $.devices[0].address
+	Path string
+	// Line of the value in the configuration file, 0 if unknown
+	Line    int
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	msg := fmt.Sprintf("%s: %s", e.Path, e.Message)
+	if e.Line > 0 {
+		msg = fmt.Sprintf("line %d: %s", e.Line, msg)
+	}
+	if e.File != "" {
+		msg = fmt.Sprintf("%s: %s", e.File, msg)
+	}
+	return msg
+}
+
+// ValidationErrors are all errors found by Validate
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+type validator struct {
+	// file the currently validated values were loaded from
+	file string
+	errs ValidationErrors
+}
+
+func (v *validator) errorf(path string, format string, args ...interface{}) {
+	v.errs = append(v.errs, &ValidationError{
+		File:    v.file,
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// Validate checks the whole configuration and returns ValidationErrors with all problems found
+func (c *Config) Validate() error {
+	v := &validator{file: c.file}
+
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		v.errorf("$.listen", "invalid listen address %q: %s", c.Listen, err)
+	}
+	paths := map[string]string{
+		"/-/reload": "",
+		"/sd":       "",
+	}
+	for _, p := range []struct{ field, value string }{
+		{"probe_path", c.ProbePath},
+		{"metrics_path", c.MetricsPath},
+	} {
+		if !strings.HasPrefix(p.value, "/") {
+			v.errorf("$."+p.field, "path %q must start with /", p.value)
+		}
+		if other, exists := paths[p.value]; exists {
+			if other == "" {
+				v.errorf("$."+p.field, "path %q is reserved", p.value)
+			} else {
+				v.errorf("$."+p.field, "path %q is already used by %s", p.value, other)
+			}
+		}
+		paths[p.value] = p.field
+	}
+	if c.Timeout <= 0 {
+		v.errorf("$.timeout", "must be greater than 0")
+	}
+
+	v.validateGlobal("$.global", c.Global)
+
+	profileNames := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		profileNames = append(profileNames, name)
+	}
+	sort.Strings(profileNames)
+	for _, name := range profileNames {
+		profile := c.Profiles[name]
+		path := "$.profiles." + name
+		if profile == nil {
+			v.errorf(path, "profile is empty")
+			continue
+		}
+		if profile.Timeout != nil && *profile.Timeout <= 0 {
+			v.errorf(path+".timeout", "must be greater than 0")
+		}
+		if _, err := strconv.Atoi(profile.PON); profile.PON != "" && err != nil {
+			v.errorf(path+".pon", "invalid PON %q, expected its number", profile.PON)
+		}
+	}
+
+	names := map[string]int{}
+	for i, device := range c.Devices {
+		source := c.deviceSource(i)
+		v.file = source.file
+		path := source.path()
+		if device.Name == "" {
+			v.errorf(path+".name", "name is empty")
+		} else if first, exists := names[device.Name]; exists {
+			v.errorf(path+".name", "non-unique target name %q, already used by %s", device.Name, c.describeDevice(first, source))
+		} else {
+			names[device.Name] = i
+		}
+		v.validateAddress(path+".address", device.Address)
+		profile, ok := c.GetProfile(device.Profile)
+		if device.Profile != "" && !ok {
+			v.errorf(path+".profile", "unknown profile %q", device.Profile)
+		}
+		// a timeout inherited from the profile or config is checked there
+		inherited := device.Timeout == &c.Timeout || (ok && device.Timeout == profile.Timeout)
+		if device.Timeout != nil && !inherited && *device.Timeout <= 0 {
+			v.errorf(path+".timeout", "must be greater than 0")
+		}
+		v.validateDevice(path, device, &c.Global)
+	}
+
+	if len(v.errs) > 0 {
+		return v.errs
+	}
+	return nil
+}
+
+func (v *validator) validateAddress(path string, address string) {
+	if address == "" {
+		v.errorf(path, "address is empty")
+		return
+	}
+	if strings.Contains(address, "://") {
+		v.errorf(path, "address %q must not contain a scheme, use http_config.scheme", address)
+		return
+	}
+	host := address
+	if h, port, err := net.SplitHostPort(address); err == nil {
+		host = h
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			v.errorf(path, "invalid port in address %q", address)
+		}
+	} else {
+		host = strings.Trim(host, "[]")
+	}
+	if host == "" || strings.ContainsAny(host, "/ ") {
+		v.errorf(path, "invalid address %q, expected a hostname or IP with optional port", address)
+	}
+}
+
+func (v *validator) validateGlobal(path string, global Global) {
+	if global.PollInterval < 0 {
+		v.errorf(path+".poll_interval", "must not be negative")
+	}
+	if global.Concurrency < 1 {
+		v.errorf(path+".concurrency", "must be at least 1")
+	}
+	if global.TokenMaxAge < 0 {
+		v.errorf(path+".token_max_age", "must not be negative")
+	}
+	v.validateTLSConfig(path+".tls_config", global.TLSConfig)
+	v.validateHTTPConfig(path+".http_config", global.HTTPConfig)
+	v.validateLabels(path+".labels", global.Labels)
+}
+
+// validateDevice checks the device after the global values have been applied,
+// values inherited from global are only reported once by validateGlobal
+func (v *validator) validateDevice(path string, device *Device, global *Global) {
+	if device.Username == nil || *device.Username == "" {
+		v.errorf(path, "username is empty, set it for the device or globally")
+	}
+	if device.Password == nil || *device.Password == "" {
+		v.errorf(path, "password is empty, set it for the device or globally")
+	}
+	if device.PollInterval != &global.PollInterval && *device.PollInterval < 0 {
+		v.errorf(path+".poll_interval", "must not be negative")
+	}
+	if device.Concurrency != &global.Concurrency && *device.Concurrency < 1 {
+		v.errorf(path+".concurrency", "must be at least 1")
+	}
+	if device.TokenMaxAge != &global.TokenMaxAge && *device.TokenMaxAge < 0 {
+		v.errorf(path+".token_max_age", "must not be negative")
+	}
+	// the probes of the ONU targets filter the polled snapshot, otherwise each would fetch all ONUs from the device
+	if *device.DiscoverONUs && *device.PollInterval <= 0 {
+		discoverPath := path
+		if device.DiscoverONUs != &global.DiscoverONUs {
+			discoverPath += ".discover_onus"
+		}
+		v.errorf(discoverPath, "discover_onus requires a poll_interval")
+	}
+
+	if device.TLSConfig != &global.TLSConfig {
+		v.validateTLSConfig(path+".tls_config", *device.TLSConfig)
+	}
+	if device.HTTPConfig != &global.HTTPConfig {
+		v.validateHTTPConfig(path+".http_config", *device.HTTPConfig)
+	}
+	labels := map[string]string{}
+	for name, value := range device.Labels {
+		if _, ok := global.Labels[name]; !ok {
+			labels[name] = value
+		}
+	}
+	v.validateLabels(path+".labels", labels)
+}
+
+func (v *validator) validateTLSConfig(path string, tlsConfig TLSConfig) {
+	if tlsConfig.Fingerprint != "" {
+		fingerprint, err := hex.DecodeString(strings.ReplaceAll(tlsConfig.Fingerprint, ":", ""))
+		if err != nil || len(fingerprint) != 32 {
+			v.errorf(path+".fingerprint", "expected a SHA-256 fingerprint in hex")
+		}
+	}
+	if tlsConfig.TrustOnFirstUse && tlsConfig.FingerprintFile == "" {
+		v.errorf(path+".trust_on_first_use", "requires a fingerprint_file")
+	}
+	if tlsConfig.CAFile != "" {
+		ca, err := os.ReadFile(tlsConfig.CAFile)
+		if err != nil {
+			v.errorf(path+".ca_file", "error reading CA file: %s", err)
+		} else if !x509.NewCertPool().AppendCertsFromPEM(ca) {
+			v.errorf(path+".ca_file", "no certificates found in CA file %s", tlsConfig.CAFile)
+		}
+	}
+}
+
+func (v *validator) validateHTTPConfig(path string, httpConfig HTTPConfig) {
+	if httpConfig.Scheme != "http" && httpConfig.Scheme != "https" {
+		v.errorf(path+".scheme", "must be http or https")
+	}
+	if httpConfig.Port < 0 || httpConfig.Port > 65535 {
+		v.errorf(path+".port", "invalid port %d", httpConfig.Port)
+	}
+	if httpConfig.ProxyURL != "" {
+		proxyURL, err := url.Parse(httpConfig.ProxyURL)
+		if err != nil {
+			v.errorf(path+".proxy_url", "invalid URL %q: %s", httpConfig.ProxyURL, err)
+		} else if proxyURL.Scheme == "" || proxyURL.Host == "" {
+			v.errorf(path+".proxy_url", "URL %q must have a scheme and a host", httpConfig.ProxyURL)
+		}
+	}
+	if httpConfig.SourceAddress != "" && net.ParseIP(httpConfig.SourceAddress) == nil {
+		v.errorf(path+".source_address", "invalid IP %q", httpConfig.SourceAddress)
+	}
+	if httpConfig.RequestTimeout <= 0 {
+		v.errorf(path+".request_timeout", "must be greater than 0")
+	}
+}
+
+// annotateLines sets the line of each error from the contents of its file,
+// if a path does not exist in the file its closest parent is used
+func annotateLines(err error, contents map[string][]byte) {
+	errs, ok := err.(ValidationErrors)
+	if !ok {
+		return
+	}
+	files := map[string]*ast.File{}
+	for _, e := range errs {
+		file, parsed := files[e.File]
+		if !parsed {
+			data, ok := contents[e.File]
+			if !ok {
+				continue
+			}
+			file, _ = parser.ParseBytes(data, 0)
+			files[e.File] = file
+		}
+		if file == nil {
+			continue
+		}
+
+		path := e.Path
+		for path != "$" && path != "" {
+			if line := lineOf(file, path); line > 0 {
+				e.Line = line
+				break
+			}
+			i := strings.LastIndexAny(path, ".[")
+			if i < 0 {
+				break
+			}
+			path = path[:i]
+		}
+	}
+}
+func lineOf(file *ast.File, path string) int {
+	p, err := yaml.PathString(path)
+	if err != nil {
+		return 0
+	}
+	node, err := p.FilterFile(file)
+	if err != nil || node == nil || node.GetToken() == nil {
+		return 0
+	}
+	return node.GetToken().Position.Line
+}
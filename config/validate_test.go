@@ -0,0 +1,329 @@
+package config
+
+import (
+	"bytes"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	_, err := loadConfig(t, `metrics_path: /probe
+global:
+  username: ubnt
+  password: ubnt
+  concurrency: 0
+devices:
+  - name: olt1
+    address: 192.168.1.1
+  - name: olt1
+    address: https://192.168.1.2
+  - name: olt3
+    address: 192.168.1.3:99999
+    password: ""
+    http_config:
+      scheme: ftp
+`)
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected validation errors, got %v", err)
+	}
+
+	expected := []string{
+		`line 1: $.metrics_path: path "/probe" is already used by probe_path`,
+		`line 5: $.global.concurrency: must be at least 1`,
+		`line 9: $.devices[1].name: non-unique target name "olt1", already used by devices[0]`,
+		`line 10: $.devices[1].address: address "https://192.168.1.2" must not contain a scheme, use http_config.scheme`,
+		`line 12: $.devices[2].address: invalid port in address "192.168.1.3:99999"`,
+		`line 11: $.devices[2]: password is empty, set it for the device or globally`,
+		`line 15: $.devices[2].http_config.scheme: must be http or https`,
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors, got %d:\n%s", len(expected), len(errs), errs)
+	}
+	for i, e := range errs {
+		if !strings.HasSuffix(e.Error(), ": "+expected[i]) {
+			t.Errorf("error %d: expected %q, got %q", i, expected[i], e.Error())
+		}
+	}
+}
+
+func TestValidateReservedPath(t *testing.T) {
+	_, err := loadConfig(t, `probe_path: /-/reload`)
+	if err == nil || !strings.Contains(err.Error(), `path "/-/reload" is reserved`) {
+		t.Fatalf("expected reserved path error, got %v", err)
+	}
+}
+
+func TestValidateEmpty(t *testing.T) {
+	c, err := loadConfig(t, ``)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Listen != ":9777" || c.ProbePath != "/probe" || c.Global.Concurrency != 3 {
+		t.Errorf("expected defaults, got %+v", c)
+	}
+}
+
+func TestValidateInclude(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "conf.d"), 0o700)
+	os.WriteFile(filepath.Join(dir, "conf.d", "a.yml"), []byte(`devices:
+  - name: olt2
+    address: 192.168.1.2
+`), 0o600)
+	os.WriteFile(filepath.Join(dir, "conf.d", "b.yml"), []byte(`devices:
+  - name: olt3
+    address: 192.168.1.3
+  - name: olt1
+    address: 192.168.1.4
+`), 0o600)
+	os.WriteFile(filepath.Join(dir, "config.yml"), []byte(`include:
+  - conf.d
+global:
+  username: ubnt
+  password: ubnt
+devices:
+  - name: olt1
+    address: 192.168.1.1
+`), 0o600)
+
+	_, err := Load(filepath.Join(dir, "config.yml"))
+	var errs ValidationErrors
+	if !errors.As(err, &errs) || len(errs) != 1 {
+		t.Fatalf("expected one validation error, got %v", err)
+	}
+	expected := filepath.Join(dir, "conf.d", "b.yml") + `: line 4: $.devices[1].name: non-unique target name "olt1", already used by devices[0] in ` + filepath.Join(dir, "config.yml")
+	if errs[0].Error() != expected {
+		t.Errorf("expected %q, got %q", expected, errs[0].Error())
+	}
+
+	os.WriteFile(filepath.Join(dir, "conf.d", "b.yml"), []byte(`devices:
+  - name: olt3
+    address: 192.168.1.3
+`), 0o600)
+	c, err := Load(filepath.Join(dir, "config.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Devices) != 3 || len(c.Files()) != 3 {
+		t.Fatalf("expected 3 devices from 3 files, got %d from %v", len(c.Devices), c.Files())
+	}
+	if watched := c.WatchFiles(); watched[len(watched)-1] != filepath.Join(dir, "conf.d") {
+		t.Errorf("expected the include directory to be watched, got %v", watched)
+	}
+	device, ok := c.GetDevice("olt3")
+	if !ok || *device.Username != "ubnt" {
+		t.Errorf("expected included device with global username, got %+v", device)
+	}
+
+	os.WriteFile(filepath.Join(dir, "conf.d", "c.yml"), []byte(`devices: {`), 0o600)
+	_, err = Load(filepath.Join(dir, "config.yml"))
+	if err == nil || !strings.Contains(err.Error(), "c.yml") {
+		t.Errorf("expected parse error naming the included file, got %v", err)
+	}
+}
+
+func TestValidateLabels(t *testing.T) {
+	c, err := loadConfig(t, `global:
+  username: ubnt
+  password: ubnt
+  labels:
+    region: eu
+    site: unknown
+devices:
+  - name: olt1
+    address: olt1
+    labels:
+      site: zrh
+  - name: olt2
+    address: olt2
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	olt1, _ := c.GetDevice("olt1")
+	if len(olt1.Labels) != 2 || olt1.Labels["region"] != "eu" || olt1.Labels["site"] != "zrh" {
+		t.Errorf("expected device labels merged with global labels, got %v", olt1.Labels)
+	}
+	olt2, _ := c.GetDevice("olt2")
+	if olt2.Labels["site"] != "unknown" {
+		t.Errorf("expected global labels, got %v", olt2.Labels)
+	}
+
+	_, err = loadConfig(t, `global:
+  labels:
+    serial: x
+devices:
+  - name: olt1
+    address: olt1
+    username: ubnt
+    password: ubnt
+    labels:
+      __name__: x
+      site-name: x
+      instance: x
+      job: x
+`)
+	for _, expected := range []string{
+		`line 3: $.global.labels.serial: label name "serial" is used by the metrics of the exporter`,
+		`line 10: $.devices[0].labels.__name__: invalid label name "__name__"`,
+		`line 11: $.devices[0].labels.site-name: invalid label name "site-name"`,
+		`line 12: $.devices[0].labels.instance: label name "instance" is set by Prometheus for the target`,
+		`line 13: $.devices[0].labels.job: label name "job" is set by Prometheus for the target`,
+	} {
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q, got %v", expected, err)
+		}
+	}
+}
+
+// writeCA writes the certificate of the test server count times to a CA file
+func writeCA(t *testing.T, file string, count int) {
+	t.Helper()
+	ts := httptest.NewTLSServer(nil)
+	ts.Close()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	err := os.WriteFile(file, bytes.Repeat(ca, count), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTLSConfigVerify(t *testing.T) {
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	writeCA(t, ca, 1)
+	c, err := loadConfig(t, fmt.Sprintf(`global:
+  username: ubnt
+  password: ubnt
+devices:
+  - name: olt1
+    address: olt1
+  - name: olt2
+    address: olt2
+    tls_config:
+      ca_file: %[1]s
+  - name: olt3
+    address: olt3
+    tls_config:
+      server_name: olt.example.com
+  - name: olt4
+    address: olt4
+    tls_config:
+      ca_file: %[1]s
+      insecure_skip_verify: true
+`, ca))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, skip := range map[string]bool{"olt1": true, "olt2": false, "olt3": false, "olt4": true} {
+		device, _ := c.GetDevice(name)
+		if device.TLSConfig.InsecureSkipVerify != skip {
+			t.Errorf("%s: expected insecure_skip_verify %v, got %v", name, skip, device.TLSConfig.InsecureSkipVerify)
+		}
+	}
+}
+
+func TestValidateTLSFiles(t *testing.T) {
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "invalid.pem")
+	os.WriteFile(invalid, []byte("no certificate"), 0o600)
+
+	_, err := loadConfig(t, fmt.Sprintf(`global:
+  username: ubnt
+  password: ubnt
+  tls_config:
+    ca_file: %[1]s/missing.pem
+devices:
+  - name: olt1
+    address: olt1
+    tls_config:
+      ca_file: %[1]s/invalid.pem
+    http_config:
+      proxy_url: "://proxy"
+  - name: olt2
+    address: olt2
+    http_config:
+      proxy_url: proxy:3128
+`, dir))
+	for _, expected := range []string{
+		`line 5: $.global.tls_config.ca_file: error reading CA file`,
+		`line 10: $.devices[0].tls_config.ca_file: no certificates found in CA file`,
+		`line 12: $.devices[0].http_config.proxy_url: invalid URL "://proxy"`,
+		`line 16: $.devices[1].http_config.proxy_url: URL "proxy:3128" must have a scheme and a host`,
+	} {
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected error containing %q, got %v", expected, err)
+		}
+	}
+}
+
+func TestTLSFilesChanged(t *testing.T) {
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	writeCA(t, ca, 1)
+	configYAML := fmt.Sprintf(`global:
+  username: ubnt
+  password: ubnt
+devices:
+  - name: olt1
+    address: olt1
+    tls_config:
+      ca_file: %s
+`, ca)
+
+	c, err := loadConfig(t, configYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(c.WatchFiles(), ca) {
+		t.Errorf("expected CA file to be watched, got %v", c.WatchFiles())
+	}
+	before, _ := c.GetDevice("olt1")
+
+	// a renewed CA changes the device, so its client is created again
+	writeCA(t, ca, 2)
+	c, err = loadConfig(t, configYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, _ := c.GetDevice("olt1")
+	if reflect.DeepEqual(*before, *after) {
+		t.Error("expected device with changed CA file to differ")
+	}
+}
+
+func TestValidateDiscoverONUs(t *testing.T) {
+	_, err := loadConfig(t, `global:
+  username: ubnt
+  password: ubnt
+  discover_onus: true
+devices:
+  - name: olt1
+    address: olt1
+    poll_interval: 60
+  - name: olt2
+    address: olt2
+  - name: olt3
+    address: olt3
+    discover_onus: true
+`)
+	for _, expected := range []string{
+		`line 9: $.devices[1]: discover_onus requires a poll_interval`,
+		`line 13: $.devices[2].discover_onus: discover_onus requires a poll_interval`,
+	} {
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q, got %v", expected, err)
+		}
+	}
+	if err != nil && strings.Contains(err.Error(), "devices[0]") {
+		t.Errorf("expected polled device to be valid, got %v", err)
+	}
+}
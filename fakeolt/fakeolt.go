@@ -0,0 +1,277 @@
+// Package fakeolt implements a fake UFiber OLT API serving fixture files,
+// to test the exporter without hardware.
+package fakeolt
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/swoga/ufiber-exporter/model"
+)
+
+const apiPrefix = "/api/v1.0/"
+
+// Fixtures maps the served endpoints to the file names in the fixture directory
+var Fixtures = map[string]string{
+	"statistics":         "statistics.json",
+	"interfaces":         "interfaces.json",
+	"gpon/onus":          "onus.json",
+	"gpon/onus/settings": "onussettings.json",
+	"tools/mac-table":    "mactable.json",
+}
+
+type Server struct {
+	Username string
+	Password string
+	// TokenTTL is the time after which issued tokens are rejected, 0 means tokens never expire
+	TokenTTL time.Duration
+
+	fixtures map[string][]byte
+
+	mutex     sync.Mutex
+	tokens    map[string]time.Time
+	latencies map[string]time.Duration
+	blocks    map[string]chan struct{}
+	errors    map[string]int
+	requests  map[string]int
+}
+
+// New creates a server that serves the fixture files found in dir
+func New(dir string) (*Server, error) {
+	s := &Server{
+		Username:  "ubnt",
+		Password:  "ubnt",
+		fixtures:  map[string][]byte{},
+		tokens:    map[string]time.Time{},
+		latencies: map[string]time.Duration{},
+		blocks:    map[string]chan struct{}{},
+		errors:    map[string]int{},
+		requests:  map[string]int{},
+	}
+
+	for endpoint, file := range Fixtures {
+		data, err := os.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			return nil, fmt.Errorf("error reading fixture for %s: %w", endpoint, err)
+		}
+		s.fixtures[endpoint] = data
+	}
+
+	return s, nil
+}
+
+// NewTLSServer starts a httptest TLS server, the caller should call Close when finished
+func (s *Server) NewTLSServer() *httptest.Server {
+	return httptest.NewTLSServer(s)
+}
+
+// SetLatency delays all responses of endpoint by d, "" applies to all endpoints
+func (s *Server) SetLatency(endpoint string, d time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.latencies[endpoint] = d
+}
+
+// Block holds all requests of endpoint until Unblock is called
+func (s *Server) Block(endpoint string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.blocks[endpoint]; !ok {
+		s.blocks[endpoint] = make(chan struct{})
+	}
+}
+
+// Unblock releases the held requests of endpoint
+func (s *Server) Unblock(endpoint string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if block, ok := s.blocks[endpoint]; ok {
+		close(block)
+		delete(s.blocks, endpoint)
+	}
+}
+
+// SetError lets endpoint respond with statusCode, 0 removes the error again
+func (s *Server) SetError(endpoint string, statusCode int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if statusCode == 0 {
+		delete(s.errors, endpoint)
+		return
+	}
+	s.errors[endpoint] = statusCode
+}
+
+// ExpireTokens invalidates all issued tokens
+func (s *Server) ExpireTokens() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.tokens = map[string]time.Time{}
+}
+
+// Requests returns how often endpoint was requested
+func (s *Server) Requests(endpoint string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.requests[endpoint]
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := strings.CutPrefix(r.URL.Path, apiPrefix)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not found")
+		return
+	}
+
+	s.mutex.Lock()
+	s.requests[endpoint]++
+	latency := s.latencies[""] + s.latencies[endpoint]
+	statusCode := s.errors[endpoint]
+	block := s.blocks[endpoint]
+	s.mutex.Unlock()
+
+	if block != nil {
+		select {
+		case <-block:
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if statusCode != 0 {
+		writeError(w, statusCode, http.StatusText(statusCode))
+		return
+	}
+
+	if endpoint == "user/login" {
+		s.handleLogin(w, r)
+		return
+	}
+	if endpoint == "user/logout" {
+		s.handleLogout(w, r)
+		return
+	}
+
+	data, ok := s.fixtures[endpoint]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !s.validToken(r.Header.Get("X-Auth-Token")) {
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var login model.LoginRequest
+	err := json.NewDecoder(r.Body).Decode(&login)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request")
+		return
+	}
+	if login.Username != s.Username || login.Password != s.Password {
+		writeError(w, http.StatusUnauthorized, "Invalid credentials")
+		return
+	}
+
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	token := hex.EncodeToString(buf)
+
+	s.mutex.Lock()
+	s.tokens[token] = time.Now()
+	s.mutex.Unlock()
+
+	w.Header().Set("X-Auth-Token", token)
+	writeJSON(w, http.StatusOK, model.LoginResponse{
+		StatusCode: http.StatusOK,
+		Message:    "Success",
+	})
+}
+
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	token := r.Header.Get("X-Auth-Token")
+	if !s.validToken(token) {
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	s.mutex.Lock()
+	delete(s.tokens, token)
+	s.mutex.Unlock()
+
+	writeJSON(w, http.StatusOK, model.ErrorResponse{
+		StatusCode: http.StatusOK,
+		Message:    "Success",
+	})
+}
+
+// Sessions returns the number of issued tokens that are not logged out or expired
+func (s *Server) Sessions() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.tokens)
+}
+
+func (s *Server) validToken(token string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	issued, ok := s.tokens[token]
+	if !ok {
+		return false
+	}
+	if s.TokenTTL > 0 && time.Since(issued) > s.TokenTTL {
+		delete(s.tokens, token)
+		return false
+	}
+	return true
+}
+
+func writeError(w http.ResponseWriter, statusCode int, message string) {
+	writeJSON(w, statusCode, model.ErrorResponse{
+		StatusCode: statusCode,
+		Error:      1,
+		Message:    message,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(data)
+}
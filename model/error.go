@@ -0,0 +1,8 @@
+package model
+
+type ErrorResponse struct {
+	Detail     string `json:"detail"`
+	Error      int    `json:"error"`
+	Message    string `json:"message"`
+	StatusCode int    `json:"statusCode"`
+}